@@ -1,26 +1,34 @@
 package coco
 
-// #include <stdlib.h>
-// #include <stdio.h>
-import "C"
-
 import (
 	"encoding/json"
-	"unsafe"
+	"errors"
+	"sort"
+	"sync"
 )
 
 // The following API functions are defined:
 //  CocoApi	- CocoApi api class that loads COCO annotation file and prepare data structures.
 //  DecodeSegmentToMask - Decode binary mask M encoded via run-length encoding.
+//  DecodeSegment       - DecodeSegmentToMask returning an error.
 //  EncodeMaskToSegment - Encode binary mask M using run-length encoding.
 //  EncodeRLEToSegment  - Encode binary mask M using run-length encoding.
 //  GetAnnIds  - Get ann ids that satisfy given filter conditions.
+//  QueryAnnIds - Get sorted ann ids that satisfy every filter of an AnnQuery.
 //  GetCatIds  - Get cat ids that satisfy given filter conditions.
 //  GetImgIds  - Get img ids that satisfy given filter conditions.
+//  GetCatIdsWith/GetImgIdsWith - The filters of pycocotools getCatIds/getImgIds.
+//  FilterAnnIds/FilterImgIds - Get sorted ids that match a filter expression.
 //  LoadAnns   - Load anns with the specified ids.
 //  LoadCats   - Load cats with the specified ids.
 //  LoadImgs   - Load imgs with the specified ids.
 //  ShowAnns   - Display the specified annotations.
+//  Add*/Update*/Remove* - Edit images, annotations and categories in place.
+//  Dataset    - Get the current dataset.
+//  Merge      - Combine datasets, remapping colliding ids.
+//  Subset     - Get the images, annotations, categories and licenses of a selection.
+//  SplitDataset/KFold - Divide the images into stratified splits or folds.
+//  MergeCategories/DropCategories/RenumberCategories - Edit the taxonomy.
 // Throughout the API "ann"=annotation, "cat"=category, and "img"=image.
 // Help on each functions can be accessed by: "help COCO>function".
 
@@ -29,78 +37,83 @@ type CocoApi struct {
 	imgMap map[int]Image
 	annMap map[int]Annotation
 	catMap map[int]Categories
-	// catNameMap map[int]Categories
+	catNameMap map[string]Categories
+	// the index lists are sorted and hold every id once
 	imgToAnnMap map[int][]int
 	// annToImgMap map[int][]int
-	// catToAnnMap map[int][]int
+	catToAnnMap map[int][]int
 	// annToCatMap map[int][]int
-	// imgToCatMap map[int][]int
+	imgToCatMap map[int][]int
 	catToImgMap map[int][]int
+	segCache *SegmentCache
+	// positions in the slices of datasetMeta, kept by the mutations
+	imgPos map[int]int
+	annPos map[int]int
+	catPos map[int]int
+	// largest ids used so far, new ids are allocated above them
+	lastImgId, lastAnnId, lastCatId int
+	// per image box indexes, built on demand
+	indexMu sync.Mutex
+	boxIndexes map[int]*BoxIndex
+	captionIndex *CaptionIndex
 }
 
 func NewCocoApi(datasetMeta []byte) (cocoApi *CocoApi, err error) {
 	cocoApi = &CocoApi{
-		imgMap: make(map[int]Image),
-		annMap: make(map[int]Annotation),
-		catMap: make(map[int]Categories),
-		// catNameMap: make(map[string]Categories),
-		imgToAnnMap: make(map[int][]int),
-		// catToAnnMap: make(map[int][]int),
-		// imgToCatMap: make(map[int][]int),
-		catToImgMap: make(map[int][]int),
+		segCache: NewSegmentCache(),
+		boxIndexes: make(map[int]*BoxIndex),
 	}
 	err = cocoApi.init(datasetMeta)
 	return
 }
 
+//DecodeSegmentToMask decodes a RLE or RLEUncompressed segmentation leniently
+//like the C decoder, counts not summing to h*w are cut or padded with zeros.
+//It returns nil for polygons, which have no size, and other segmentations it
+//can not decode, use DecodeSegment to get the error instead.
 func DecodeSegmentToMask(segmentation SegmentationHelper) (mask []byte) {
-	stype := segmentation.SegmentationType()
-	var segment *SegmentationRLE;
-	switch (stype) {
-	case "RLE":
-		segment = segmentation.(*SegmentationRLE)
-		
-	case "RLEUncompressed":
-		segmentTmp := segmentation.(*SegmentationRLEUncompressed)
-		segment = EncodeRLEToSegment(segmentTmp)
+	// decoded in go, the counts string is never handed to C
+	switch segment := segmentation.(type) {
+	case *SegmentationRLE:
+		return segment.MaskRLE().Decode()
+	case *SegmentationRLEUncompressed:
+		return segment.MaskRLE().Decode()
 	}
+	return nil
+}
 
-	rleGoBytes := []byte(segment.Counts)
-	// 正确用法
-	charSegment := &Char{
-		Cc: unsafe.Pointer(&rleGoBytes[0]),
+//DecodeSegment decodes a RLE or RLEUncompressed segmentation into a binary
+//mask in column-major order. Malformed counts or counts not summing to h*w
+//return a *RLEError, polygons need the image size and return an error.
+func DecodeSegment(segmentation SegmentationHelper) (mask []byte, err error) {
+	var rle MaskRLE
+	switch segment := segmentation.(type) {
+	case *SegmentationRLE:
+		rle, err = DecodeRLEString(segment.Counts, segment.Size)
+	case *SegmentationRLEUncompressed:
+		rle = segment.MaskRLE()
+		err = rle.Validate()
+	case nil:
+		err = errors.New("empty segmentation")
+	default:
+		err = errors.New("segmentation type " + segmentation.SegmentationType() + " has no size")
 	}
-	// // 错误用法
-	// relBytes := C.CBytes(rleGoBytes)
-	// defer C.free(relBytes)
-	// charSegment := &Char{
-	// 	Cc: relBytes,
-	// }
-	// fmt.Println("rleGoBytes len: ", len(rleGoBytes))
-	// // 旧版用法
-	// rle := charSegment.ToRLE(segment.Size[0], segment.Size[1])
-	// 新版增加长度参数，避免越界
-	rle := charSegment.ToRLEWithByteLen(segment.Size[0], segment.Size[1], uint32(len(rleGoBytes)))
-	mask = rle.Decode()
-	return
+	if err != nil {
+		return nil, err
+	}
+	return rle.Decode(), nil
 }
 
 func EncodeMaskToSegment(mask []byte, size [2]uint32) *SegmentationRLE {
-	rle := encodeRLE(mask, size[0], size[1], 1)
-	return rleToSegment(rle, size)
+	var rle MaskRLE
+	rle.Encode(mask, size)
+	return rle.Segmentation()
 }
 
 func EncodeRLEToSegment(segmentation *SegmentationRLEUncompressed) *SegmentationRLE {
-	rle := compressRLE(segmentation.Counts, segmentation.Size[0], segmentation.Size[1])
-	return rleToSegment(rle, segmentation.Size)
-}
-
-func rleToSegment(rle *RLE, size [2]uint32) *SegmentationRLE {
-	charPointer := (* C.char)(rle.ToChar().Cc)
-	countsString := C.GoString(charPointer) // segmentation.counts
 	return &SegmentationRLE{
-		Counts: countsString,
-		Size: size,
+		Counts: countsToString(segmentation.Counts),
+		Size: segmentation.Size,
 	}
 }
 
@@ -110,28 +123,60 @@ func (api *CocoApi) init(datasetMeta []byte) (err error) {
 		// fmt.Println("json.unmarshal failed,err:",err)
 		return
 	}
+	api.createIndex()
+	return
+}
+
+// createIndex builds the maps from the dataset slices, the largest ids never
+// go down so that removed ids are not reused.
+func (api *CocoApi) createIndex() {
+	api.imgMap = make(map[int]Image)
+	api.annMap = make(map[int]Annotation)
+	api.catMap = make(map[int]Categories)
+	api.catNameMap = make(map[string]Categories)
+	api.imgToAnnMap = make(map[int][]int)
+	api.catToAnnMap = make(map[int][]int)
+	api.imgToCatMap = make(map[int][]int)
+	api.catToImgMap = make(map[int][]int)
+	api.imgPos = make(map[int]int)
+	api.annPos = make(map[int]int)
+	api.catPos = make(map[int]int)
 
-	// createIndex
 	imgs := api.datasetMeta.Images
 	for i := 0; i < len(imgs); i++ {		
 		api.imgMap[imgs[i].ID] = imgs[i]
+		api.imgPos[imgs[i].ID] = i
+		api.lastImgId = maxInt(api.lastImgId, imgs[i].ID)
 	}
 
 	cats := api.datasetMeta.Categories
 	for i := 0; i < len(cats); i++ {
-		// api.catNameMap[cats[i].Name] = cats[i]
+		api.catNameMap[cats[i].Name] = cats[i]
 		api.catMap[cats[i].ID] = cats[i]
+		api.catPos[cats[i].ID] = i
+		api.lastCatId = maxInt(api.lastCatId, cats[i].ID)
 	}
 
 	anns := api.datasetMeta.Annotations
+	pairs := make(map[[2]int]bool)
 	for i := 0; i < len(anns); i++ {		
 		api.annMap[anns[i].ID] = anns[i]
+		api.annPos[anns[i].ID] = i
+		api.lastAnnId = maxInt(api.lastAnnId, anns[i].ID)
 		api.imgToAnnMap[anns[i].ImageID] = append(api.imgToAnnMap[anns[i].ImageID], anns[i].ID)
-		// api.catToAnnMap[anns[i].CategoryID] = append(api.catToAnnMap[anns[i].CategoryID], anns[i].ID)
-		// api.imgToCatMap[anns[i].ImageID] = append(api.imgToCatMap[anns[i].ImageID], anns[i].CategoryID)
-		api.catToImgMap[anns[i].CategoryID] = append(api.catToImgMap[anns[i].CategoryID], anns[i].ImageID)
+		api.catToAnnMap[anns[i].CategoryID] = append(api.catToAnnMap[anns[i].CategoryID], anns[i].ID)
+		pair := [2]int{anns[i].ImageID, anns[i].CategoryID}
+		if !pairs[pair] {
+			pairs[pair] = true
+			api.imgToCatMap[anns[i].ImageID] = append(api.imgToCatMap[anns[i].ImageID], anns[i].CategoryID)
+			api.catToImgMap[anns[i].CategoryID] = append(api.catToImgMap[anns[i].CategoryID], anns[i].ImageID)
+		}
+	}
+	for _, index := range []map[int][]int{api.imgToAnnMap, api.catToAnnMap, api.imgToCatMap, api.catToImgMap} {
+		for _, ids := range index {
+			sort.Ints(ids)
+		}
 	}
-	return
 }
 
 func (api *CocoApi) GetLicense() ([]License) {
@@ -142,6 +187,9 @@ func (api *CocoApi) GetInfo() (Information) {
 	return api.datasetMeta.Info
 }
 
+//GetAnnIds Get ann ids that satisfy given filter conditions. areaRng is only
+//applied together with catIds and an iscrowd other than 0 or 1 disables the
+//crowd filter, QueryAnnIds applies every filter and sorts its result.
 func (api *CocoApi) GetAnnIds(imgIds, catIds, areaRng []int, iscrowd byte) (ids []int) {
 	var anns map[int]Annotation
 	if len(imgIds) == 0 && len(catIds) == 0 && len(areaRng) == 0 {
@@ -157,7 +205,11 @@ func (api *CocoApi) GetAnnIds(imgIds, catIds, areaRng []int, iscrowd byte) (ids
 				anns[list[i]] = api.annMap[list[i]]
 			}
 		} else {
-			anns = api.annMap
+			// filtered below, api.annMap must not lose its entries
+			anns = make(map[int]Annotation, len(api.annMap))
+			for k, v := range api.annMap {
+				anns[k] = v
+			}
 		}
 		if len(catIds) != 0 {
 			catIdMap := make(map[int]int)
@@ -186,48 +238,19 @@ func (api *CocoApi) GetAnnIds(imgIds, catIds, areaRng []int, iscrowd byte) (ids
 		}
 		ids = append(ids, v.ID)
 	}
+	sort.Ints(ids)
 	return
 }
 
+//GetCatIds Get cat ids with any of the names and supercategory names, sorted
 func (api *CocoApi) GetCatIds(names, supCatNames []string) (ids []int) {
-    
-    nameMap := make(map[string]byte)
-	for i := 0; i < len(names); i++ {
-		nameMap[names[i]] = 1
-	}
-	superNameMap :=  make(map[string]byte)
-	for i := 0; i < len(supCatNames); i++ {
-		superNameMap[supCatNames[i]] = 1
-	}
-
-	for _, v := range api.catMap {
-		if len(names) > 0 {
-			if _, ok := nameMap[v.Name]; !ok {
-				continue
-			}
-		}
-		if len(supCatNames) > 0 {
-			if _, ok := superNameMap[v.Supercategory]; !ok {
-				continue
-			}
-		}
-		ids = append(ids, v.ID)
-	}
-
-	return
+	return api.GetCatIdsWith(names, supCatNames, nil)
 }
 
+//GetImgIds Get img ids that contain any of catIds, sorted. Use GetImgIdsWith
+//for the intersection of pycocotools.
 func (api *CocoApi) GetImgIds(catIds []int) (ids []int) {
-
-	if len(catIds) < 1 {
-		ids = getImageMapKeys(api.imgMap)
-		return
-	}
-	for i := 0; i < len(catIds); i++ {
-		ids = append(ids, api.catToImgMap[catIds[i]]...)
-	}
-	ids = removeDuplicates(ids)
-	return
+	return api.GetImgIdsWith(nil, catIds, SetUnion)
 }
 
 func (api *CocoApi) LoadAnns(ids []int) (list []Annotation) {
@@ -254,33 +277,3 @@ func (api *CocoApi) LoadImgs(ids []int) (list []Image) {
 func (api *CocoApi) ShowAnns(ids []int) ([]interface{}, error) {
 	return nil, nil
 }
-
-func removeDuplicates(ids []int) (filteredIds []int) {
-    if len(ids) == 0 {
-        return
-    }
-
-    idMap := make(map[int]byte)
-	for _, v:= range ids{
-	    idMap[v] = 1
-	}
-
-	j := 0
-	keys := make([]int, len(idMap))
-	for k := range idMap {
-		keys[j] = k
-		j++
-	}
-	filteredIds = keys
-	return
-}
-
-func getImageMapKeys(m map[int]Image) []int {
-	j := 0
-	keys := make([]int, len(m))
-	for k := range m {
-		keys[j] = k
-		j++
-	}
-	return keys
-}
@@ -63,6 +63,16 @@ func Test_DecodeSegmentToMask2(t *testing.T) {
 	}
 }
 
+func Test_DecodeSegmentPolygon(t *testing.T) {
+	poly := &SegmentationPolygon{{1, 1, 4, 1, 4, 4}}
+	if mask, err := DecodeSegment(poly); err == nil || mask != nil {
+		t.Fatal("expected an error for a polygon without size")
+	}
+	if mask := DecodeSegmentToMask(poly); mask != nil {
+		t.Fatal("expected no mask for a polygon without size")
+	}
+}
+
 func Test_DecodeSegmentToMask3(t *testing.T) {
 	counts := "XS^51;0Gb0g8_O`G3l0LoNP2\\8WNdG27GFm2Y8Y1O9iGcKO=Y7f4O1O1EkJTI\\5Z6eJfI02e5U6?K5O12N0000L4O1O1F:O1000000O11OO10000000O10O1001O00O100O1O12N00O2O01O001ON2O1O1O11O1O001OQL"
 	// for i:=0; i< 10000; i++ {
@@ -0,0 +1,379 @@
+package coco
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Full-text search over captions. Captions are lowercased and split into
+// words at every rune that is not a letter or digit. Queries match images:
+//  zebra umbrella        - both words in captions of the image (implicit AND)
+//  zebra AND umbrella    - the same
+//  zebra OR horse        - either word
+//  zebra NOT grass       - zebra but no caption with grass
+//  "red umbrella"        - the words in this order in one caption
+//  (zebra OR horse) AND "red umbrella"
+// Operators must be upper case, a lower case and/or/not is a word. Results
+// are ranked with BM25 over the captions of each image.
+
+//CaptionHit is an image matching a caption query
+type CaptionHit struct {
+	ImageID int
+	Score   float64
+	// captions of the image that contain a searched word or phrase
+	Snippets []string
+}
+
+//CaptionIndex is an inverted index over the captions of annotations, it is
+//read-only after construction and safe for concurrent use
+type CaptionIndex struct {
+	// term -> caption ann id -> word positions
+	postings map[string]map[int][]int
+	captions map[int]Annotation
+	// image -> caption ann ids, sorted
+	imgCaps map[int][]int
+	imgLen  map[int]int
+	avgLen  float64
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+//NewCaptionIndex builds an index over the captions of anns, annotations
+//without a caption are skipped
+func NewCaptionIndex(anns []Annotation) *CaptionIndex {
+	ix := &CaptionIndex{
+		postings: make(map[string]map[int][]int),
+		captions: make(map[int]Annotation),
+		imgCaps:  make(map[int][]int),
+		imgLen:   make(map[int]int),
+	}
+	total := 0
+	for _, ann := range anns {
+		if ann.Caption == "" {
+			continue
+		}
+		ix.captions[ann.ID] = ann
+		ix.imgCaps[ann.ImageID] = append(ix.imgCaps[ann.ImageID], ann.ID)
+		words := tokenize(ann.Caption)
+		ix.imgLen[ann.ImageID] += len(words)
+		total += len(words)
+		for pos, w := range words {
+			if ix.postings[w] == nil {
+				ix.postings[w] = make(map[int][]int)
+			}
+			ix.postings[w][ann.ID] = append(ix.postings[w][ann.ID], pos)
+		}
+	}
+	for _, ids := range ix.imgCaps {
+		sort.Ints(ids)
+	}
+	if len(ix.imgCaps) > 0 {
+		ix.avgLen = float64(total) / float64(len(ix.imgCaps))
+	}
+	return ix
+}
+
+// captionQuery is a node of a parsed query, words is set for a phrase
+// (a single word is a phrase of length one).
+type captionQuery struct {
+	op    string // "word", "and", "or", "not"
+	words []string
+	args  []*captionQuery
+}
+
+type captionParser struct {
+	tokens []string
+	quoted []bool
+	pos    int
+}
+
+func lexCaptionQuery(q string) (tokens []string, quoted []bool, err error) {
+	rs := []rune(q)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, string(r))
+			quoted = append(quoted, false)
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				j++
+			}
+			if j == len(rs) {
+				return nil, nil, errors.New("unterminated phrase")
+			}
+			tokens = append(tokens, string(rs[i+1:j]))
+			quoted = append(quoted, true)
+			i = j + 1
+		default:
+			j := i
+			for j < len(rs) && !unicode.IsSpace(rs[j]) && rs[j] != '(' && rs[j] != ')' && rs[j] != '"' {
+				j++
+			}
+			tokens = append(tokens, string(rs[i:j]))
+			quoted = append(quoted, false)
+			i = j
+		}
+	}
+	return tokens, quoted, nil
+}
+
+func (p *captionParser) peek() (string, bool) {
+	if p.pos >= len(p.tokens) {
+		return "", false
+	}
+	return p.tokens[p.pos], p.quoted[p.pos]
+}
+
+func (p *captionParser) parseOr() (*captionQuery, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if t, q := p.peek(); q || t != "OR" {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &captionQuery{op: "or", args: []*captionQuery{left, right}}
+	}
+}
+
+func (p *captionParser) parseAnd() (*captionQuery, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, q := p.peek()
+		if p.pos >= len(p.tokens) || !q && (t == "OR" || t == ")") {
+			return left, nil
+		}
+		if !q && t == "AND" {
+			p.pos++
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &captionQuery{op: "and", args: []*captionQuery{left, right}}
+	}
+}
+
+func (p *captionParser) parseUnary() (*captionQuery, error) {
+	t, q := p.peek()
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end of query")
+	}
+	p.pos++
+	if q {
+		words := tokenize(t)
+		if len(words) == 0 {
+			return nil, errors.New("empty phrase")
+		}
+		return &captionQuery{op: "word", words: words}, nil
+	}
+	switch t {
+	case "NOT":
+		arg, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &captionQuery{op: "not", args: []*captionQuery{arg}}, nil
+	case "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, q := p.peek(); q || t != ")" {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return e, nil
+	case ")", "AND", "OR":
+		return nil, errors.New("unexpected " + t)
+	}
+	words := tokenize(t)
+	if len(words) == 0 {
+		return nil, errors.New("no word in " + t)
+	}
+	return &captionQuery{op: "word", words: words}, nil
+}
+
+func parseCaptionQuery(query string) (*captionQuery, error) {
+	tokens, quoted, err := lexCaptionQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty query")
+	}
+	p := &captionParser{tokens: tokens, quoted: quoted}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(tokens) {
+		return nil, errors.New("unexpected " + tokens[p.pos])
+	}
+	return e, nil
+}
+
+// phraseCaptions returns the captions that contain the words in order.
+func (ix *CaptionIndex) phraseCaptions(words []string) map[int]bool {
+	out := make(map[int]bool)
+	for ann, starts := range ix.postings[words[0]] {
+	next:
+		for _, p := range starts {
+			for k := 1; k < len(words); k++ {
+				pos := ix.postings[words[k]][ann]
+				i := sort.SearchInts(pos, p+k)
+				if i == len(pos) || pos[i] != p+k {
+					continue next
+				}
+			}
+			out[ann] = true
+			break
+		}
+	}
+	return out
+}
+
+// eval returns the matching images and collects the phrases that are not
+// negated into positive.
+func (ix *CaptionIndex) eval(e *captionQuery, negated bool, positive *[][]string) map[int]bool {
+	switch e.op {
+	case "word":
+		if !negated {
+			*positive = append(*positive, e.words)
+		}
+		imgs := make(map[int]bool)
+		for ann := range ix.phraseCaptions(e.words) {
+			imgs[ix.captions[ann].ImageID] = true
+		}
+		return imgs
+	case "not":
+		inner := ix.eval(e.args[0], !negated, positive)
+		imgs := make(map[int]bool)
+		for img := range ix.imgCaps {
+			if !inner[img] {
+				imgs[img] = true
+			}
+		}
+		return imgs
+	case "and":
+		a, b := ix.eval(e.args[0], negated, positive), ix.eval(e.args[1], negated, positive)
+		for img := range a {
+			if !b[img] {
+				delete(a, img)
+			}
+		}
+		return a
+	default:
+		a, b := ix.eval(e.args[0], negated, positive), ix.eval(e.args[1], negated, positive)
+		for img := range b {
+			a[img] = true
+		}
+		return a
+	}
+}
+
+// termFreqs counts the occurrences of w in the captions of every image.
+func (ix *CaptionIndex) termFreqs(w string) map[int]int {
+	tf := make(map[int]int)
+	for ann, pos := range ix.postings[w] {
+		tf[ix.captions[ann].ImageID] += len(pos)
+	}
+	return tf
+}
+
+// bm25 scores an image by the term frequencies of the searched words.
+func (ix *CaptionIndex) bm25(img int, tfs map[string]map[int]int) float64 {
+	const k1, b = 1.2, 0.75
+	n := float64(len(ix.imgCaps))
+	norm := k1 * (1 - b + b*float64(ix.imgLen[img])/ix.avgLen)
+	score := 0.0
+	for _, tf := range tfs {
+		f := float64(tf[img])
+		if f == 0 {
+			continue
+		}
+		df := float64(len(tf))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		score += idf * f * (k1 + 1) / (f + norm)
+	}
+	return score
+}
+
+//Search Get the images matching query ranked by BM25, ties are ordered by
+//image id
+func (ix *CaptionIndex) Search(query string) ([]CaptionHit, error) {
+	e, err := parseCaptionQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	var positive [][]string
+	imgs := ix.eval(e, false, &positive)
+	tfs := make(map[string]map[int]int)
+	phrases := make([]map[int]bool, len(positive))
+	for i, p := range positive {
+		for _, w := range p {
+			if tfs[w] == nil {
+				tfs[w] = ix.termFreqs(w)
+			}
+		}
+		phrases[i] = ix.phraseCaptions(p)
+	}
+
+	hits := make([]CaptionHit, 0, len(imgs))
+	for img := range imgs {
+		hit := CaptionHit{ImageID: img, Score: ix.bm25(img, tfs)}
+		for _, ann := range ix.imgCaps[img] {
+			for _, caps := range phrases {
+				if caps[ann] {
+					hit.Snippets = append(hit.Snippets, ix.captions[ann].Caption)
+					break
+				}
+			}
+		}
+		hits = append(hits, hit)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ImageID < hits[j].ImageID
+	})
+	return hits, nil
+}
+
+//CaptionIndex Get the index over the captions of all annotations, it is
+//built on first use and shared afterwards
+func (api *CocoApi) CaptionIndex() *CaptionIndex {
+	api.indexMu.Lock()
+	defer api.indexMu.Unlock()
+	if api.captionIndex == nil {
+		api.captionIndex = NewCaptionIndex(api.datasetMeta.Annotations)
+	}
+	return api.captionIndex
+}
+
+//SearchCaptions Get the images whose captions match query, see CaptionIndex.Search
+func (api *CocoApi) SearchCaptions(query string) ([]CaptionHit, error) {
+	return api.CaptionIndex().Search(query)
+}
@@ -0,0 +1,115 @@
+package coco
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var testCaptions = []Annotation{
+	{ID: 1, ImageID: 10, Caption: "A zebra stands under a red umbrella."},
+	{ID: 2, ImageID: 10, Caption: "Zebra in the grass"},
+	{ID: 3, ImageID: 11, Caption: "An umbrella that is red, and a horse."},
+	{ID: 4, ImageID: 11, Caption: "A horse eating grass."},
+	{ID: 5, ImageID: 12, Caption: "Two zebras and a zebra foal."},
+	{ID: 6, ImageID: 13, Caption: "A zebra next to a zebra, zebra everywhere"},
+	{ID: 7, ImageID: 13, Caption: "Umbrella"},
+	{ID: 8, ImageID: 14},
+}
+
+func hitImages(hits []CaptionHit) []int {
+	ids := []int{}
+	for _, h := range hits {
+		ids = append(ids, h.ImageID)
+	}
+	return ids
+}
+
+func Test_SearchCaptions(t *testing.T) {
+	ix := NewCaptionIndex(testCaptions)
+	cases := []struct {
+		query string
+		want  []int
+	}{
+		{"zebra AND umbrella", []int{13, 10}},
+		{"zebra umbrella", []int{13, 10}},
+		{"ZEBRA", []int{13, 12, 10}},
+		{`"red umbrella"`, []int{10}},
+		{`"umbrella red"`, []int{}},
+		{"horse OR zebra", []int{13, 11, 12, 10}},
+		{"zebra NOT grass", []int{13, 12}},
+		{"NOT zebra", []int{11}},
+		{`(horse OR zebras) AND NOT "red umbrella"`, []int{12, 11}},
+		{"and", []int{12, 11}},
+	}
+	for _, c := range cases {
+		hits, err := ix.Search(c.query)
+		if err != nil {
+			t.Fatalf("%s: %v", c.query, err)
+		}
+		got := hitImages(hits)
+		// ranking is checked below, compare the sets here
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: %v, expected %v", c.query, got, c.want)
+		}
+		want := make(map[int]bool)
+		for _, id := range c.want {
+			want[id] = true
+		}
+		for _, id := range got {
+			if !want[id] {
+				t.Fatalf("%s: %v, expected %v", c.query, got, c.want)
+			}
+		}
+	}
+
+	// the image saying zebra three times ranks first
+	hits, _ := ix.Search("zebra")
+	if hits[0].ImageID != 13 || hits[0].Score <= hits[len(hits)-1].Score {
+		t.Fatalf("ranking %+v", hits)
+	}
+	// snippets are the captions with a searched word
+	hits, _ = ix.Search("zebra AND umbrella")
+	for _, h := range hits {
+		if h.ImageID == 10 && len(h.Snippets) != 2 || h.ImageID == 13 && len(h.Snippets) != 2 {
+			t.Fatalf("snippets %+v", h)
+		}
+	}
+	hits, _ = ix.Search("zebra NOT grass")
+	for _, h := range hits {
+		for _, s := range h.Snippets {
+			if s == "Umbrella" {
+				t.Fatalf("snippet %q matches no searched word", s)
+			}
+		}
+	}
+
+	for _, q := range []string{"", "zebra AND", "(zebra", `"zebra`, "zebra )", "OR zebra", `""`, "NOT"} {
+		if _, err := ix.Search(q); err == nil {
+			t.Fatalf("%q: expected an error", q)
+		}
+	}
+}
+
+func Test_CocoApiSearchCaptions(t *testing.T) {
+	data, err := json.Marshal(CocoData{
+		Images:      []Image{{ID: 10}, {ID: 11}, {ID: 12}, {ID: 13}, {ID: 14}},
+		Annotations: testCaptions,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := NewCocoApi(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits, err := api.SearchCaptions(`"red umbrella" OR "that is red"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hitImages(hits); len(got) != 2 {
+		t.Fatalf("hits %v", got)
+	}
+	if api.CaptionIndex() != api.CaptionIndex() {
+		t.Fatal("caption index is rebuilt")
+	}
+}
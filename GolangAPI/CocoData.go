@@ -25,6 +25,9 @@ type Image struct {
 	FlickrURL    string `json:"flickr_url,omitempty"`
 	CocoURL      string `json:"coco_url,omitempty"`
 	DateCaptured string `json:"date_captured,omitempty"`
+
+	// fields not known to Image, kept for marshaling
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 //License is the license information and is shared between all the formats
@@ -42,6 +45,9 @@ type CocoData struct {
 
 	// ImageCaption does not have this property
 	Categories  []Categories `json:"categories,omitempty"`
+
+	// fields not known to CocoData, kept for marshaling
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 //Annotation is the object detection annotation
@@ -70,6 +76,12 @@ type Annotation struct {
 	// PanopticSegmentation own property
 	FileName     string          `json:"file_name,omitempty"`
 	SegmentsInfo []PSSegmentInfo `json:"segments_info,omitempty"`
+
+	// Result own property
+	Score        float32    `json:"score,omitempty"`
+
+	// fields not known to Annotation, e.g. occluded or truncated, kept for marshaling
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 //Edge desribes a 2 point edge Probably [x,y] I haven't tested it yet
@@ -88,6 +100,9 @@ type Categories struct {
 	// PanopticSegmentation own property
 	Isthing       byte      `json:"isthing,omitempty"`
 	Color         [3]uint32 `json:"color,omitempty"`
+
+	// fields not known to Categories, e.g. the LVIS frequency, kept for marshaling
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
 //PSSegmentInfo contains segment info for the annotation
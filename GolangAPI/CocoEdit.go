@@ -0,0 +1,294 @@
+package coco
+
+import (
+	"errors"
+	"sort"
+)
+
+// Editing a loaded dataset. Every index, the dataset slices and the caches
+// are kept up to date, the box and caption indexes are rebuilt on their
+// next use.
+//  AddImage/AddCategory/AddAnnotation          - Add with the given or a new id.
+//  UpdateImage/UpdateCategory/UpdateAnnotation - Replace the entry with the same id.
+//  RemoveImage/RemoveCategory                  - Remove together with their annotations.
+//  RemoveAnnotation                            - Remove one annotation.
+//  Dataset                                     - Get the current dataset.
+// An id of 0 is allocated above the largest id used so far, so ids of
+// removed entries are not reused. Edits must not run concurrently with
+// other calls on the same CocoApi.
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// insertId adds id to the sorted ids unless it is present.
+func insertId(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i < len(ids) && ids[i] == id {
+		return ids
+	}
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+func indexAdd(index map[int][]int, key, id int) {
+	index[key] = insertId(index[key], id)
+}
+
+// indexRemove removes id from the list of key, empty lists are deleted like
+// init never creates them.
+func indexRemove(index map[int][]int, key, id int) {
+	ids := index[key]
+	i := sort.SearchInts(ids, id)
+	if i == len(ids) || ids[i] != id {
+		return
+	}
+	ids = append(ids[:i], ids[i+1:]...)
+	if len(ids) == 0 {
+		delete(index, key)
+	} else {
+		index[key] = ids
+	}
+}
+
+func (api *CocoApi) indexAnn(ann Annotation) {
+	api.annMap[ann.ID] = ann
+	indexAdd(api.imgToAnnMap, ann.ImageID, ann.ID)
+	indexAdd(api.catToAnnMap, ann.CategoryID, ann.ID)
+	indexAdd(api.imgToCatMap, ann.ImageID, ann.CategoryID)
+	indexAdd(api.catToImgMap, ann.CategoryID, ann.ImageID)
+	api.dropIndexes(ann)
+}
+
+func (api *CocoApi) unindexAnn(ann Annotation) {
+	delete(api.annMap, ann.ID)
+	indexRemove(api.imgToAnnMap, ann.ImageID, ann.ID)
+	indexRemove(api.catToAnnMap, ann.CategoryID, ann.ID)
+	shared := false
+	for _, id := range api.imgToAnnMap[ann.ImageID] {
+		if api.annMap[id].CategoryID == ann.CategoryID {
+			shared = true
+			break
+		}
+	}
+	if !shared {
+		indexRemove(api.imgToCatMap, ann.ImageID, ann.CategoryID)
+		indexRemove(api.catToImgMap, ann.CategoryID, ann.ImageID)
+	}
+	api.segCache.forget(ann.Segmentation.SegmentationHelper)
+	api.dropIndexes(ann)
+}
+
+// dropIndexes discards the lazy indexes that cover ann.
+func (api *CocoApi) dropIndexes(ann Annotation) {
+	api.indexMu.Lock()
+	delete(api.boxIndexes, ann.ImageID)
+	if ann.Caption != "" {
+		api.captionIndex = nil
+	}
+	api.indexMu.Unlock()
+}
+
+// dropAnns removes the annotations in ids from the dataset, keeping the
+// order of the others.
+func (api *CocoApi) dropAnns(ids map[int]bool) {
+	anns := api.datasetMeta.Annotations[:0]
+	for _, ann := range api.datasetMeta.Annotations {
+		if ids[ann.ID] {
+			delete(api.annPos, ann.ID)
+			continue
+		}
+		api.annPos[ann.ID] = len(anns)
+		anns = append(anns, ann)
+	}
+	api.datasetMeta.Annotations = anns
+}
+
+// removeAnns removes the annotations in ids and returns them as a sorted list.
+func (api *CocoApi) removeAnns(ids []int) []int {
+	ids = copyIds(ids)
+	drop := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		api.unindexAnn(api.annMap[id])
+		drop[id] = true
+	}
+	if len(drop) > 0 {
+		api.dropAnns(drop)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// checkAnn verifies that the image and category of ann exist. Category 0
+// is only allowed for datasets without categories like captions and for
+// panoptic annotations, whose categories are those of their segments.
+func (api *CocoApi) checkAnn(ann Annotation) error {
+	if _, ok := api.imgMap[ann.ImageID]; !ok {
+		return errors.New("image not found")
+	}
+	if ann.CategoryID == 0 && (len(api.catMap) == 0 || len(ann.SegmentsInfo) > 0) {
+		return nil
+	}
+	if _, ok := api.catMap[ann.CategoryID]; !ok {
+		return errors.New("category not found")
+	}
+	return nil
+}
+
+//AddImage adds img and returns its id
+func (api *CocoApi) AddImage(img Image) (int, error) {
+	if img.ID == 0 {
+		img.ID = api.lastImgId + 1
+	} else if _, ok := api.imgMap[img.ID]; ok {
+		return 0, errors.New("image id exists")
+	}
+	api.lastImgId = maxInt(api.lastImgId, img.ID)
+	api.imgMap[img.ID] = img
+	api.imgPos[img.ID] = len(api.datasetMeta.Images)
+	api.datasetMeta.Images = append(api.datasetMeta.Images, img)
+	return img.ID, nil
+}
+
+//AddCategory adds cat and returns its id
+func (api *CocoApi) AddCategory(cat Categories) (int, error) {
+	if cat.ID == 0 {
+		cat.ID = api.lastCatId + 1
+	} else if _, ok := api.catMap[cat.ID]; ok {
+		return 0, errors.New("category id exists")
+	}
+	api.lastCatId = maxInt(api.lastCatId, cat.ID)
+	api.catMap[cat.ID] = cat
+	api.catNameMap[cat.Name] = cat
+	api.catPos[cat.ID] = len(api.datasetMeta.Categories)
+	api.datasetMeta.Categories = append(api.datasetMeta.Categories, cat)
+	return cat.ID, nil
+}
+
+//AddAnnotation adds ann to an existing image and category and returns its id
+func (api *CocoApi) AddAnnotation(ann Annotation) (int, error) {
+	if err := api.checkAnn(ann); err != nil {
+		return 0, err
+	}
+	if ann.ID == 0 {
+		ann.ID = api.lastAnnId + 1
+	} else if _, ok := api.annMap[ann.ID]; ok {
+		return 0, errors.New("annotation id exists")
+	}
+	api.lastAnnId = maxInt(api.lastAnnId, ann.ID)
+	api.indexAnn(ann)
+	api.annPos[ann.ID] = len(api.datasetMeta.Annotations)
+	api.datasetMeta.Annotations = append(api.datasetMeta.Annotations, ann)
+	return ann.ID, nil
+}
+
+//UpdateImage replaces the image with the id of img
+func (api *CocoApi) UpdateImage(img Image) error {
+	if _, ok := api.imgMap[img.ID]; !ok {
+		return errors.New("image not found")
+	}
+	api.imgMap[img.ID] = img
+	api.datasetMeta.Images[api.imgPos[img.ID]] = img
+	return nil
+}
+
+// nameCat points catNameMap at the last category called name like init.
+func (api *CocoApi) nameCat(name string) {
+	delete(api.catNameMap, name)
+	for _, c := range api.datasetMeta.Categories {
+		if c.Name == name {
+			api.catNameMap[name] = c
+		}
+	}
+}
+
+//UpdateCategory replaces the category with the id of cat
+func (api *CocoApi) UpdateCategory(cat Categories) error {
+	old, ok := api.catMap[cat.ID]
+	if !ok {
+		return errors.New("category not found")
+	}
+	api.catMap[cat.ID] = cat
+	api.datasetMeta.Categories[api.catPos[cat.ID]] = cat
+	api.nameCat(old.Name)
+	api.nameCat(cat.Name)
+	return nil
+}
+
+//UpdateAnnotation replaces the annotation with the id of ann, which may
+//move to another image or category
+func (api *CocoApi) UpdateAnnotation(ann Annotation) error {
+	old, ok := api.annMap[ann.ID]
+	if !ok {
+		return errors.New("annotation not found")
+	}
+	if err := api.checkAnn(ann); err != nil {
+		return err
+	}
+	api.unindexAnn(old)
+	api.indexAnn(ann)
+	api.datasetMeta.Annotations[api.annPos[ann.ID]] = ann
+	return nil
+}
+
+//RemoveAnnotation removes the annotation with the given id
+func (api *CocoApi) RemoveAnnotation(id int) error {
+	if _, ok := api.annMap[id]; !ok {
+		return errors.New("annotation not found")
+	}
+	api.removeAnns([]int{id})
+	return nil
+}
+
+//RemoveImage removes an image and its annotations, it returns the ids of
+//the removed annotations
+func (api *CocoApi) RemoveImage(id int) ([]int, error) {
+	if _, ok := api.imgMap[id]; !ok {
+		return nil, errors.New("image not found")
+	}
+	removed := api.removeAnns(api.imgToAnnMap[id])
+	delete(api.imgMap, id)
+	pos := api.imgPos[id]
+	delete(api.imgPos, id)
+	imgs := api.datasetMeta.Images
+	api.datasetMeta.Images = append(imgs[:pos], imgs[pos+1:]...)
+	for i := pos; i < len(api.datasetMeta.Images); i++ {
+		api.imgPos[api.datasetMeta.Images[i].ID] = i
+	}
+	return removed, nil
+}
+
+//RemoveCategory removes a category and its annotations, it returns the ids
+//of the removed annotations
+func (api *CocoApi) RemoveCategory(id int) ([]int, error) {
+	cat, ok := api.catMap[id]
+	if !ok {
+		return nil, errors.New("category not found")
+	}
+	removed := api.removeAnns(api.catToAnnMap[id])
+	delete(api.catMap, id)
+	pos := api.catPos[id]
+	delete(api.catPos, id)
+	cats := api.datasetMeta.Categories
+	api.datasetMeta.Categories = append(cats[:pos], cats[pos+1:]...)
+	for i := pos; i < len(api.datasetMeta.Categories); i++ {
+		api.catPos[api.datasetMeta.Categories[i].ID] = i
+	}
+	api.nameCat(cat.Name)
+	return removed, nil
+}
+
+//Dataset Get the current dataset, the slices are copies that later edits
+//do not change
+func (api *CocoApi) Dataset() CocoData {
+	data := api.datasetMeta
+	data.Images = append([]Image(nil), data.Images...)
+	data.Annotations = append([]Annotation(nil), data.Annotations...)
+	data.Categories = append([]Categories(nil), data.Categories...)
+	data.Licenses = append([]License(nil), data.Licenses...)
+	return data
+}
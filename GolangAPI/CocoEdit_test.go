@@ -0,0 +1,187 @@
+package coco
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// checkRebuilt compares the indexes of api with those of an api loaded
+// from its dataset.
+func checkRebuilt(t *testing.T, api *CocoApi, step string) {
+	data, err := json.Marshal(api.Dataset())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := NewCocoApi(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			t.Fatalf("%s: %s differs from a reload\n%v\n%v", step, name, a, b)
+		}
+	}
+	same("images", api.GetImgIdsWith(nil, nil, SetUnion), fresh.GetImgIdsWith(nil, nil, SetUnion))
+	same("annotations", api.GetAnnIds(nil, nil, nil, 2), fresh.GetAnnIds(nil, nil, nil, 2))
+	same("categories", api.GetCatIds(nil, nil), fresh.GetCatIds(nil, nil))
+	same("imgToAnnMap", api.imgToAnnMap, fresh.imgToAnnMap)
+	same("catToAnnMap", api.catToAnnMap, fresh.catToAnnMap)
+	same("imgToCatMap", api.imgToCatMap, fresh.imgToCatMap)
+	same("catToImgMap", api.catToImgMap, fresh.catToImgMap)
+	same("imgPos", api.imgPos, fresh.imgPos)
+	same("annPos", api.annPos, fresh.annPos)
+	same("catPos", api.catPos, fresh.catPos)
+	for name, cat := range fresh.catNameMap {
+		same("catNameMap "+name, api.catNameMap[name].ID, cat.ID)
+	}
+	same("catNameMap size", len(api.catNameMap), len(fresh.catNameMap))
+	for id, ann := range fresh.annMap {
+		same("annotation", api.annMap[id].Bbox, ann.Bbox)
+	}
+	for _, img := range fresh.GetImgIdsWith(nil, nil, SetUnion) {
+		r := [4]float32{0, 0, 60, 60}
+		same("box index", api.BoxIndex(img).Intersecting(r), fresh.BoxIndex(img).Intersecting(r))
+	}
+	a, _ := api.SearchCaptions("red OR bus OR dog")
+	b, _ := fresh.SearchCaptions("red OR bus OR dog")
+	same("captions", hitImages(a), hitImages(b))
+}
+
+func Test_EditCocoApi(t *testing.T) {
+	api, err := NewCocoApi([]byte(filterDataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// build the lazy indexes so that edits must drop them
+	api.BoxIndex(1)
+	api.CaptionIndex()
+
+	imgId, err := api.AddImage(Image{Width: 100, Height: 80, FileName: "c.jpg"})
+	if err != nil || imgId != 3 {
+		t.Fatalf("AddImage: %d %v", imgId, err)
+	}
+	catId, err := api.AddCategory(Categories{Name: "dog", Supercategory: "animal"})
+	if err != nil || catId != 4 {
+		t.Fatalf("AddCategory: %d %v", catId, err)
+	}
+	annId, err := api.AddAnnotation(Annotation{ImageID: imgId, CategoryID: catId, Area: 50, Bbox: [4]float32{1, 2, 10, 5}, Caption: "a dog"})
+	if err != nil || annId != 6 {
+		t.Fatalf("AddAnnotation: %d %v", annId, err)
+	}
+	checkRebuilt(t, api, "add")
+	if ids, _ := api.FilterAnnIds(`cat == "dog" and img.file_name == "c.jpg"`); !equalInts(ids, []int{6}) {
+		t.Fatalf("filter after add: %v", ids)
+	}
+	if _, err := api.AddAnnotation(Annotation{ImageID: 99, CategoryID: 1}); err == nil {
+		t.Fatal("expected an error for a missing image")
+	}
+	if _, err := api.AddAnnotation(Annotation{ImageID: 1, CategoryID: 99}); err == nil {
+		t.Fatal("expected an error for a missing category")
+	}
+	if _, err := api.AddAnnotation(Annotation{ImageID: 1}); err == nil {
+		t.Fatal("expected an error for category 0 in a dataset with categories")
+	}
+	other, _ := NewCocoApi([]byte(filterDataset))
+	if _, err := other.AddAnnotation(Annotation{ImageID: 1, SegmentsInfo: []PSSegmentInfo{{ID: 1, CategoryID: 1}}}); err != nil {
+		t.Fatalf("panoptic annotation rejected: %v", err)
+	}
+	captions, _ := NewCocoApi([]byte(`{"images": [{"id": 1}]}`))
+	if _, err := captions.AddAnnotation(Annotation{ImageID: 1, Caption: "a caption"}); err != nil {
+		t.Fatalf("caption rejected: %v", err)
+	}
+	if _, err := api.AddImage(Image{ID: 1}); err == nil {
+		t.Fatal("expected an error for a used id")
+	}
+
+	// move an annotation to another image and category
+	ann := api.annMap[2]
+	ann.ImageID, ann.CategoryID, ann.Bbox = 3, 4, [4]float32{30, 30, 5, 5}
+	if err := api.UpdateAnnotation(ann); err != nil {
+		t.Fatal(err)
+	}
+	checkRebuilt(t, api, "update annotation")
+	if got := api.ImgToCats(1); !equalInts(got, []int{1}) {
+		t.Fatalf("categories of image 1 after the move: %v", got)
+	}
+	if err := api.UpdateCategory(Categories{ID: 2, Name: "automobile", Supercategory: "vehicle"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := api.CatByName("car"); ok {
+		t.Fatal("old name still found")
+	}
+	if ids := api.GetCatIds([]string{"automobile"}, nil); !equalInts(ids, []int{2}) {
+		t.Fatalf("renamed category: %v", ids)
+	}
+	if err := api.UpdateImage(Image{ID: 3, Width: 200, Height: 80}); err != nil {
+		t.Fatal(err)
+	}
+	checkRebuilt(t, api, "update")
+
+	removed, err := api.RemoveImage(1)
+	if err != nil || !equalInts(removed, []int{1, 3}) {
+		t.Fatalf("RemoveImage: %v %v", removed, err)
+	}
+	checkRebuilt(t, api, "remove image")
+	removed, err = api.RemoveCategory(4)
+	if err != nil || !equalInts(removed, []int{2, 6}) {
+		t.Fatalf("RemoveCategory: %v %v", removed, err)
+	}
+	checkRebuilt(t, api, "remove category")
+	if err := api.RemoveAnnotation(4); err != nil {
+		t.Fatal(err)
+	}
+	checkRebuilt(t, api, "remove annotation")
+	if err := api.RemoveAnnotation(4); err == nil {
+		t.Fatal("expected an error for a removed annotation")
+	}
+	// ids are not reused
+	if id, _ := api.AddAnnotation(Annotation{ImageID: 2, CategoryID: 1}); id != 7 {
+		t.Fatalf("new id %d", id)
+	}
+	checkRebuilt(t, api, "add after remove")
+}
+
+func Test_EditCocoApiRandom(t *testing.T) {
+	api, err := NewCocoApi([]byte(filterDataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(46))
+	pick := func(ids []int) int {
+		if len(ids) == 0 {
+			return -1
+		}
+		return ids[rnd.Intn(len(ids))]
+	}
+	for step := 0; step < 300; step++ {
+		imgs, cats := api.GetImgIdsWith(nil, nil, SetUnion), api.GetCatIds(nil, nil)
+		anns := api.GetAnnIds(nil, nil, nil, 2)
+		box := [4]float32{rnd.Float32() * 50, rnd.Float32() * 50, rnd.Float32() * 20, rnd.Float32() * 20}
+		switch op := rnd.Intn(10); {
+		case op < 1 || len(imgs) == 0:
+			api.AddImage(Image{Width: 64, Height: 64})
+		case op < 2 || len(cats) == 0:
+			api.AddCategory(Categories{Name: []string{"a", "b", "c"}[rnd.Intn(3)]})
+		case op < 5:
+			api.AddAnnotation(Annotation{ImageID: pick(imgs), CategoryID: pick(cats), Bbox: box, Caption: []string{"", "red dog"}[rnd.Intn(2)]})
+		case op < 7 && len(anns) > 0:
+			api.UpdateAnnotation(Annotation{ID: pick(anns), ImageID: pick(imgs), CategoryID: pick(cats), Bbox: box})
+		case op < 8 && len(anns) > 0:
+			api.RemoveAnnotation(pick(anns))
+		case op < 9:
+			api.UpdateCategory(Categories{ID: pick(cats), Name: []string{"a", "b", "bus"}[rnd.Intn(3)]})
+		default:
+			if rnd.Intn(2) == 0 {
+				api.RemoveImage(pick(imgs))
+			} else {
+				api.RemoveCategory(pick(cats))
+			}
+		}
+		if step%10 == 0 {
+			checkRebuilt(t, api, "random")
+		}
+	}
+	checkRebuilt(t, api, "random")
+}
@@ -0,0 +1,368 @@
+package coco
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Fusion of several result sets, e.g. of different models or of test-time
+// augmentation passes of one model.
+//  FuseResults        - Weighted Boxes Fusion with mask voting and keypoint averaging.
+//  UndoTTA            - Map results of a flipped/rescaled input back to the image.
+//  FlipPairsFromNames - Left/right keypoint pairs from category keypoint names.
+
+//FusionOptions controls FuseResults
+type FusionOptions struct {
+	// one weight per source, all sources weigh 1 when empty
+	Weights []float32
+	// boxes overlapping a fused box by more than IoUThreshold join it, defaults to 0.55
+	IoUThreshold float64
+	// results scoring below SkipScore are ignored
+	SkipScore float32
+	// fused masks keep the pixels whose weighted vote reaches MaskThreshold, defaults to 0.5
+	MaskThreshold float64
+}
+
+type fusedMember struct {
+	ann    Annotation
+	score  float64
+	source int
+}
+
+type fusedCluster struct {
+	box     [4]float64 // x1, y1, x2, y2
+	members []fusedMember
+}
+
+func (c *fusedCluster) update() {
+	var box [4]float64
+	sum := 0.0
+	for _, m := range c.members {
+		b := m.ann.Bbox
+		box[0] += m.score * float64(b[0])
+		box[1] += m.score * float64(b[1])
+		box[2] += m.score * float64(b[0]+b[2])
+		box[3] += m.score * float64(b[1]+b[3])
+		sum += m.score
+	}
+	if sum > 0 {
+		for k := range box {
+			box[k] /= sum
+		}
+	}
+	c.box = box
+}
+
+func (c *fusedCluster) xywh() [4]float32 {
+	return [4]float32{float32(c.box[0]), float32(c.box[1]), float32(c.box[2] - c.box[0]), float32(c.box[3] - c.box[1])}
+}
+
+//FuseResults fuses the results of several sources with Weighted Boxes Fusion.
+//Results of the same image and category whose boxes overlap are averaged
+//weighted by score and source weight. When the members carry RLE masks the
+//fused mask is the thresholded weighted vote of the member masks, when they
+//carry keypoints every visible keypoint is averaged the same way. The
+//output is ordered by image id and descending score.
+func FuseResults(sources [][]Annotation, opts FusionOptions) ([]Annotation, error) {
+	weights := opts.Weights
+	if len(weights) == 0 {
+		weights = make([]float32, len(sources))
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	if len(weights) != len(sources) {
+		return nil, errors.New("need one weight per source")
+	}
+	weightSum := 0.0
+	for _, w := range weights {
+		weightSum += float64(w)
+	}
+	thr := opts.IoUThreshold
+	if thr <= 0 {
+		thr = 0.55
+	}
+	maskThr := opts.MaskThreshold
+	if maskThr <= 0 {
+		maskThr = 0.5
+	}
+
+	groups := make(map[nmsGroup][]fusedMember)
+	var keys []nmsGroup
+	for s, results := range sources {
+		for _, r := range results {
+			if r.Score < opts.SkipScore {
+				continue
+			}
+			key := nmsGroup{imgId: r.ImageID, catId: r.CategoryID}
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], fusedMember{ann: r, score: float64(r.Score) * float64(weights[s]), source: s})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].imgId != keys[j].imgId {
+			return keys[i].imgId < keys[j].imgId
+		}
+		return keys[i].catId < keys[j].catId
+	})
+
+	var out []Annotation
+	for _, key := range keys {
+		members := groups[key]
+		sort.SliceStable(members, func(i, j int) bool { return members[i].score > members[j].score })
+		var clusters []*fusedCluster
+		for _, m := range members {
+			best, bestIoU := -1, thr
+			for i, c := range clusters {
+				if o := boxIoU(c.xywh(), m.ann.Bbox); o > bestIoU {
+					best, bestIoU = i, o
+				}
+			}
+			if best < 0 {
+				clusters = append(clusters, &fusedCluster{})
+				best = len(clusters) - 1
+			}
+			clusters[best].members = append(clusters[best].members, m)
+			clusters[best].update()
+		}
+
+		var fused []Annotation
+		for _, c := range clusters {
+			r, err := fuseCluster(c, key, weightSum, len(sources), maskThr)
+			if err != nil {
+				return nil, err
+			}
+			fused = append(fused, r)
+		}
+		out = append(out, fused...)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].ImageID != out[j].ImageID {
+			return out[i].ImageID < out[j].ImageID
+		}
+		return out[i].Score > out[j].Score
+	})
+	return out, nil
+}
+
+func fuseCluster(c *fusedCluster, key nmsGroup, weightSum float64, sources int, maskThr float64) (Annotation, error) {
+	sum := 0.0
+	for _, m := range c.members {
+		sum += m.score
+	}
+	n := len(c.members)
+	if n > sources {
+		n = sources
+	}
+	r := Annotation{
+		ImageID:    key.imgId,
+		CategoryID: key.catId,
+		Bbox:       c.xywh(),
+		Score:      float32(sum / float64(len(c.members)) * float64(n) / weightSum),
+	}
+	r.Area = r.Bbox[2] * r.Bbox[3]
+
+	// mask voting among the members that have a mask
+	var votes []float64
+	var size [2]uint32
+	maskSum := 0.0
+	for _, m := range c.members {
+		if m.ann.Segmentation.SegmentationHelper == nil {
+			continue
+		}
+		maskSum += m.score
+		rle, err := NewMaskRLE(m.ann.Segmentation.SegmentationHelper)
+		if err != nil {
+			return r, err
+		}
+		if votes == nil {
+			size = rle.Size
+			votes = make([]float64, int(size[0])*int(size[1]))
+		} else if rle.Size != size {
+			return r, errors.New("fused masks have different sizes")
+		}
+		for x, col := range countsToColumns(rle.Counts, size[0], size[1]) {
+			for _, s := range col {
+				for y := s.start; y < s.end; y++ {
+					votes[x*int(size[0])+int(y)] += m.score
+				}
+			}
+		}
+	}
+	if votes != nil {
+		mask := make([]byte, len(votes))
+		for i, v := range votes {
+			if v/maskSum >= maskThr {
+				mask[i] = 1
+			}
+		}
+		var rle MaskRLE
+		rle.Encode(mask, size)
+		r.Segmentation = Segment{rle.Segmentation()}
+		r.Area = float32(rle.Area())
+	}
+
+	// keypoint averaging
+	for _, m := range c.members {
+		if len(m.ann.Keypoints) > len(r.Keypoints) {
+			r.Keypoints = make([]float32, len(m.ann.Keypoints))
+		}
+	}
+	for k := 0; k+2 < len(r.Keypoints); k += 3 {
+		var x, y, wsum float64
+		var v float32
+		for _, m := range c.members {
+			if k+2 >= len(m.ann.Keypoints) || m.ann.Keypoints[k+2] <= 0 {
+				continue
+			}
+			x += m.score * float64(m.ann.Keypoints[k])
+			y += m.score * float64(m.ann.Keypoints[k+1])
+			wsum += m.score
+			if m.ann.Keypoints[k+2] > v {
+				v = m.ann.Keypoints[k+2]
+			}
+		}
+		if wsum > 0 {
+			r.Keypoints[k] = float32(x / wsum)
+			r.Keypoints[k+1] = float32(y / wsum)
+			r.Keypoints[k+2] = v
+			r.NumKeypoints++
+		}
+	}
+	return r, nil
+}
+
+//TTATransform describes the augmentation the results were predicted on
+type TTATransform struct {
+	// the input was mirrored horizontally
+	Flip bool
+	// size of the augmented input, 0 means the image size
+	Width, Height int
+	// keypoint index pairs that swap when the image is mirrored
+	FlipPairs [][2]int
+}
+
+//FlipPairsFromNames pairs keypoints named "left_*" and "right_*"
+func FlipPairsFromNames(names []string) [][2]int {
+	index := make(map[string]int)
+	for i, name := range names {
+		index[name] = i
+	}
+	var pairs [][2]int
+	for i, name := range names {
+		if strings.HasPrefix(name, "left_") {
+			if j, ok := index["right_"+strings.TrimPrefix(name, "left_")]; ok {
+				pairs = append(pairs, [2]int{i, j})
+			}
+		}
+	}
+	return pairs
+}
+
+//UndoTTA maps results predicted on an augmented input back into the
+//coordinates of img. Flipped results are mirrored back first, then boxes,
+//keypoints and masks are rescaled from the augmented size to the image size.
+func UndoTTA(results []Annotation, img Image, t TTATransform) ([]Annotation, error) {
+	width, height := t.Width, t.Height
+	if width <= 0 || height <= 0 {
+		width, height = img.Width, img.Height
+	}
+	sx := float32(img.Width) / float32(width)
+	sy := float32(img.Height) / float32(height)
+	out := make([]Annotation, len(results))
+	for i, r := range results {
+		if t.Flip {
+			r.Bbox[0] = float32(width) - r.Bbox[0] - r.Bbox[2]
+		}
+		r.Bbox = [4]float32{r.Bbox[0] * sx, r.Bbox[1] * sy, r.Bbox[2] * sx, r.Bbox[3] * sy}
+		r.Area *= sx * sy
+
+		if len(r.Keypoints) > 0 {
+			kps := append([]float32(nil), r.Keypoints...)
+			if t.Flip {
+				for _, p := range t.FlipPairs {
+					a, b := 3*p[0], 3*p[1]
+					if b+2 < len(kps) && a+2 < len(kps) {
+						for k := 0; k < 3; k++ {
+							kps[a+k], kps[b+k] = kps[b+k], kps[a+k]
+						}
+					}
+				}
+			}
+			for k := 0; k+2 < len(kps); k += 3 {
+				if kps[k+2] <= 0 {
+					continue
+				}
+				if t.Flip {
+					kps[k] = float32(width) - kps[k]
+				}
+				kps[k] *= sx
+				kps[k+1] *= sy
+			}
+			r.Keypoints = kps
+		}
+
+		if r.Segmentation.SegmentationHelper != nil {
+			rle, err := NewMaskRLE(r.Segmentation.SegmentationHelper)
+			if err != nil {
+				return nil, err
+			}
+			h, w := rle.Size[0], rle.Size[1]
+			cols := countsToColumns(rle.Counts, h, w)
+			if t.Flip {
+				for a, b := 0, len(cols)-1; a < b; a, b = a+1, b-1 {
+					cols[a], cols[b] = cols[b], cols[a]
+				}
+			}
+			cols = resizeColumns(cols, h, w, uint32(img.Height), uint32(img.Width))
+			size := [2]uint32{uint32(img.Height), uint32(img.Width)}
+			r.Segmentation = Segment{&SegmentationRLE{
+				Counts: countsToString(columnsToCounts(cols, size[0], size[1])),
+				Size:   size,
+			}}
+			r.Area = float32(columnsArea(cols))
+		}
+		out[i] = r
+	}
+	return out, nil
+}
+
+// resizeColumns resamples column spans from h x w to nh x nw with nearest
+// neighbour sampling of the pixel centers.
+func resizeColumns(cols [][]span, h, w, nh, nw uint32) [][]span {
+	if h == nh && w == nw {
+		return cols
+	}
+	out := make([][]span, nw)
+	if h == 0 || w == 0 {
+		return out
+	}
+	// target row y samples source row floor((y+0.5)*h/nh), so source rows
+	// [s, e) cover target rows [ceil(s*nh/h-0.5), ceil(e*nh/h-0.5))
+	row := func(s uint32) uint32 {
+		v := math.Ceil(float64(s)*float64(nh)/float64(h) - 0.5)
+		if v < 0 {
+			v = 0
+		}
+		if v > float64(nh) {
+			v = float64(nh)
+		}
+		return uint32(v)
+	}
+	for x := uint32(0); x < nw; x++ {
+		sx := uint32((float64(x) + 0.5) * float64(w) / float64(nw))
+		if sx >= w {
+			sx = w - 1
+		}
+		for _, s := range cols[sx] {
+			if a, b := row(s.start), row(s.end); a < b {
+				out[x] = append(out[x], span{a, b})
+			}
+		}
+	}
+	return out
+}
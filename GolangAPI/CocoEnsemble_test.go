@@ -0,0 +1,92 @@
+package coco
+
+import (
+	"testing"
+)
+
+func Test_FuseResults(t *testing.T) {
+	h, w := 20, 30
+	box := func(x0, y0, x1, y1 int) Segment {
+		mask := make([]byte, h*w)
+		for x := x0; x < x1; x++ {
+			for y := y0; y < y1; y++ {
+				mask[x*h+y] = 1
+			}
+		}
+		return Segment{EncodeMaskToSegment(mask, [2]uint32{uint32(h), uint32(w)})}
+	}
+	a := []Annotation{
+		{ImageID: 1, CategoryID: 1, Bbox: [4]float32{0, 0, 10, 10}, Score: 0.9, Segmentation: box(0, 0, 10, 10),
+			Keypoints: []float32{2, 2, 2, 0, 0, 0}},
+		{ImageID: 1, CategoryID: 1, Bbox: [4]float32{20, 10, 5, 5}, Score: 0.3, Segmentation: box(20, 10, 25, 15),
+			Keypoints: []float32{21, 11, 1, 0, 0, 0}},
+	}
+	b := []Annotation{
+		{ImageID: 1, CategoryID: 1, Bbox: [4]float32{2, 0, 10, 10}, Score: 0.9, Segmentation: box(2, 0, 12, 10),
+			Keypoints: []float32{4, 2, 2, 5, 5, 1}},
+	}
+	out, err := FuseResults([][]Annotation{a, b}, FusionOptions{IoUThreshold: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("fused into %d results, want 2", len(out))
+	}
+	f := out[0]
+	if f.Bbox != [4]float32{1, 0, 10, 10} || f.Score != 0.9 {
+		t.Fatalf("unexpected fused box %v score %v", f.Bbox, f.Score)
+	}
+	if f.Keypoints[0] != 3 || f.Keypoints[3] != 5 || f.NumKeypoints != 2 {
+		t.Fatalf("unexpected fused keypoints %v", f.Keypoints)
+	}
+	// both masks vote with the same weight, the union reaches 0.5 everywhere
+	if f.Area != 120 {
+		t.Fatalf("fused mask area %v, want 120", f.Area)
+	}
+	if out[1].Score != 0.15 {
+		t.Fatalf("single source result score %v, want 0.15", out[1].Score)
+	}
+
+	// a member without a mask does not dilute the vote of the masks
+	c := []Annotation{{ImageID: 1, CategoryID: 1, Bbox: [4]float32{1, 0, 10, 10}, Score: 0.9}}
+	out, err = FuseResults([][]Annotation{a, b, c}, FusionOptions{IoUThreshold: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[0].Area != 120 {
+		t.Fatalf("fused mask area %v with a box only member, want 120", out[0].Area)
+	}
+}
+
+func Test_UndoTTA(t *testing.T) {
+	img := Image{ID: 1, Width: 30, Height: 20}
+	h, w := 10, 15
+	mask := make([]byte, h*w)
+	for x := 0; x < 5; x++ {
+		for y := 2; y < 6; y++ {
+			mask[x*h+y] = 1
+		}
+	}
+	seg := EncodeMaskToSegment(mask, [2]uint32{uint32(h), uint32(w)})
+	names := []string{"nose", "left_eye", "right_eye"}
+	r := Annotation{ImageID: 1, CategoryID: 1, Bbox: [4]float32{0, 2, 5, 4}, Segmentation: Segment{seg},
+		Keypoints: []float32{1, 3, 2, 2, 3, 2, 0, 0, 0}}
+	out, err := UndoTTA([]Annotation{r}, img, TTATransform{Flip: true, Width: w, Height: h, FlipPairs: FlipPairsFromNames(names)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out[0]
+	if got.Bbox != [4]float32{20, 4, 10, 8} {
+		t.Fatalf("unexpected bbox %v", got.Bbox)
+	}
+	rle, _ := NewMaskRLE(got.Segmentation.SegmentationHelper)
+	if rle.Bbox() != got.Bbox || got.Area != 80 {
+		t.Fatalf("mask bbox %v area %v does not follow the box %v", rle.Bbox(), got.Area, got.Bbox)
+	}
+	want := []float32{28, 6, 2, 0, 0, 0, 26, 6, 2}
+	for i := range want {
+		if got.Keypoints[i] != want[i] {
+			t.Fatalf("unexpected keypoints %v", got.Keypoints)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package coco
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Unknown JSON fields of Image, Annotation, Categories and CocoData are
+// kept in their Extra map and written back by MarshalJSON, so a load/save
+// round trip keeps fields like annotator, occluded or the LVIS frequency.
+// Known fields win over an Extra entry of the same name.
+
+var knownFieldsCache sync.Map // reflect.Type -> map[string]int
+
+// jsonName returns the name of f in json, "" for a field json skips.
+func jsonName(f reflect.StructField) string {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}
+
+// knownFields returns the index of every field of t by its lower case json
+// name, the json package matches field names case-insensitively.
+func knownFields(t reflect.Type) map[string]int {
+	if v, ok := knownFieldsCache.Load(t); ok {
+		return v.(map[string]int)
+	}
+	names := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonName(t.Field(i)); name != "" {
+			names[strings.ToLower(name)] = i
+		}
+	}
+	knownFieldsCache.Store(t, names)
+	return names
+}
+
+var errJSONObject = errors.New("invalid json object")
+
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\n' || data[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// valueEnd returns the end of the json value starting at data[i], -1 when
+// it is not terminated. The value itself is not checked.
+func valueEnd(data []byte, i int) int {
+	depth := 0
+	for ; i < len(data); i++ {
+		switch data[i] {
+		case '"':
+			for i++; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(data) {
+				return -1
+			}
+			if depth == 0 {
+				return i + 1
+			}
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth == 0 {
+				// a number, true, false or null closing the enclosing value
+				return i
+			}
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		case ',', ' ', '\t', '\n', '\r':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	if depth != 0 {
+		return -1
+	}
+	return i
+}
+
+// objectFields calls fn with every key and value of the json object data,
+// the values are sub slices of data.
+func objectFields(data []byte, fn func(key string, value []byte) error) error {
+	i := skipSpace(data, 0)
+	if i == len(data) || data[i] != '{' {
+		return errJSONObject
+	}
+	if i = skipSpace(data, i+1); i < len(data) && data[i] == '}' {
+		return nil
+	}
+	for {
+		if i >= len(data) || data[i] != '"' {
+			return errJSONObject
+		}
+		end := valueEnd(data, i)
+		if end < 0 {
+			return errJSONObject
+		}
+		var key string
+		if err := json.Unmarshal(data[i:end], &key); err != nil {
+			return err
+		}
+		if i = skipSpace(data, end); i >= len(data) || data[i] != ':' {
+			return errJSONObject
+		}
+		i = skipSpace(data, i+1)
+		if end = valueEnd(data, i); end < 0 || end == i {
+			return errJSONObject
+		}
+		if err := fn(key, data[i:end]); err != nil {
+			return err
+		}
+		if i = skipSpace(data, end); i < len(data) && data[i] == ',' {
+			i = skipSpace(data, i+1)
+			continue
+		}
+		if i < len(data) && data[i] == '}' {
+			return nil
+		}
+		return errJSONObject
+	}
+}
+
+// unmarshalWithExtra decodes data into v, a pointer to a struct without
+// json methods, and returns the fields v does not know. The object is split
+// once, known fields are decoded in place and only unknown ones are copied.
+func unmarshalWithExtra(data []byte, v interface{}) (map[string]json.RawMessage, error) {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil, nil
+	}
+	known := knownFields(reflect.TypeOf(v).Elem())
+	fields := reflect.ValueOf(v).Elem()
+	var extra map[string]json.RawMessage
+	err := objectFields(data, func(name string, value []byte) error {
+		if i, ok := known[strings.ToLower(name)]; ok {
+			return json.Unmarshal(value, fields.Field(i).Addr().Interface())
+		}
+		if !json.Valid(value) {
+			return errJSONObject
+		}
+		if extra == nil {
+			extra = make(map[string]json.RawMessage)
+		}
+		extra[name] = append(json.RawMessage(nil), value...)
+		return nil
+	})
+	return extra, err
+}
+
+// marshalWithExtra encodes v, a pointer to a struct without json methods,
+// and appends the extra fields sorted by name.
+func marshalWithExtra(v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	known := knownFields(reflect.TypeOf(v).Elem())
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		if _, ok := known[strings.ToLower(name)]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.Write(data[:len(data)-1])
+	for _, name := range names {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		raw := extra[name]
+		if len(raw) == 0 {
+			raw = json.RawMessage("null")
+		}
+		buf.Write(raw)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (img *Image) UnmarshalJSON(data []byte) error {
+	type plain Image
+	p := (*plain)(img)
+	extra, err := unmarshalWithExtra(data, p)
+	img.Extra = extra
+	return err
+}
+
+func (img Image) MarshalJSON() ([]byte, error) {
+	type plain Image
+	p := plain(img)
+	return marshalWithExtra(&p, img.Extra)
+}
+
+func (ann *Annotation) UnmarshalJSON(data []byte) error {
+	type plain Annotation
+	p := (*plain)(ann)
+	extra, err := unmarshalWithExtra(data, p)
+	ann.Extra = extra
+	return err
+}
+
+func (ann Annotation) MarshalJSON() ([]byte, error) {
+	type plain Annotation
+	p := plain(ann)
+	return marshalWithExtra(&p, ann.Extra)
+}
+
+func (cat *Categories) UnmarshalJSON(data []byte) error {
+	type plain Categories
+	p := (*plain)(cat)
+	extra, err := unmarshalWithExtra(data, p)
+	cat.Extra = extra
+	return err
+}
+
+func (cat Categories) MarshalJSON() ([]byte, error) {
+	type plain Categories
+	p := plain(cat)
+	return marshalWithExtra(&p, cat.Extra)
+}
+
+func (d *CocoData) UnmarshalJSON(data []byte) error {
+	type plain CocoData
+	p := (*plain)(d)
+	extra, err := unmarshalWithExtra(data, p)
+	d.Extra = extra
+	return err
+}
+
+func (d CocoData) MarshalJSON() ([]byte, error) {
+	type plain CocoData
+	p := plain(d)
+	return marshalWithExtra(&p, d.Extra)
+}
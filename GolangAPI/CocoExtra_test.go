@@ -0,0 +1,119 @@
+package coco
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const extraDataset = `{
+	"info": {"year": 2017, "description": "extra"},
+	"images": [{"id": 1, "width": 4, "height": 3, "file_name": "a.jpg", "exif": {"iso": 200}}],
+	"annotations": [
+		{"image_id": 1, "id": 5, "category_id": 2, "segmentation": [[0, 0, 2, 0, 2, 2]], "area": 2, "bbox": [0, 0, 2, 2], "iscrowd": 1, "occluded": true, "annotator": "ann"},
+		{"image_id": 1, "id": 6, "category_id": 2, "segmentation": [[1, 1, 3, 1, 3, 2]], "area": 1, "bbox": [1, 1, 2, 1], "iscrowd": 1, "occluded": false, "truncated": 0.5}
+	],
+	"categories": [{"id": 2, "name": "zebra", "supercategory": "animal", "color": [1, 2, 3], "frequency": "r", "synonyms": ["zebra"]}],
+	"split": "val"
+}`
+
+func Test_ExtraFieldsRoundTrip(t *testing.T) {
+	var data CocoData
+	if err := json.Unmarshal([]byte(extraDataset), &data); err != nil {
+		t.Fatal(err)
+	}
+	if string(data.Extra["split"]) != `"val"` || len(data.Extra) != 1 {
+		t.Fatalf("dataset extra %v", data.Extra)
+	}
+	if len(data.Images[0].Extra) != 1 || len(data.Categories[0].Extra) != 2 {
+		t.Fatalf("image extra %v, category extra %v", data.Images[0].Extra, data.Categories[0].Extra)
+	}
+	if string(data.Annotations[0].Extra["annotator"]) != `"ann"` || len(data.Annotations[0].Extra) != 2 {
+		t.Fatalf("annotation extra %v", data.Annotations[0].Extra)
+	}
+	if data.Annotations[0].Segmentation.SegmentationHelper == nil || data.Annotations[0].ID != 5 {
+		t.Fatal("known fields were not decoded")
+	}
+
+	out, err := json.Marshal(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got interface{}
+	json.Unmarshal([]byte(extraDataset), &want)
+	json.Unmarshal(out, &got)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("round trip changed the dataset:\n%s", out)
+	}
+	// values marshal the same as pointers
+	if b, _ := json.Marshal(data.Annotations[0]); !json.Valid(b) || string(b) != mustMarshal(t, &data.Annotations[0]) {
+		t.Fatalf("value marshaling %s", b)
+	}
+
+	// known fields win over extra entries of the same name
+	img := Image{ID: 3, Extra: map[string]json.RawMessage{"id": json.RawMessage("4"), "ID": json.RawMessage("5"), "x": nil}}
+	if b := mustMarshal(t, img); b != `{"id":3,"x":null}` {
+		t.Fatalf("image %s", b)
+	}
+
+	if err := json.Unmarshal([]byte(`{"id": "3", "x": 1}`), &img); err == nil {
+		t.Fatal("expected an error for a string id")
+	}
+	if err := json.Unmarshal([]byte(`[{"id": 3}, null]`), &data.Images); err != nil || len(data.Images) != 2 || data.Images[0].Extra != nil {
+		t.Fatalf("images %+v, %v", data.Images, err)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func Test_QueryAnnIdsExtra(t *testing.T) {
+	api, err := NewCocoApi([]byte(extraDataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		extra map[string]interface{}
+		want  []int
+	}{
+		{map[string]interface{}{"occluded": true}, []int{5}},
+		{map[string]interface{}{"occluded": false}, []int{6}},
+		{map[string]interface{}{"occluded": nil}, []int{5, 6}},
+		{map[string]interface{}{"truncated": 0.5}, []int{6}},
+		{map[string]interface{}{"annotator": "ann", "occluded": true}, []int{5}},
+		{map[string]interface{}{"annotator": "bob"}, []int{}},
+		{map[string]interface{}{"missing": nil}, []int{}},
+	}
+	for _, c := range cases {
+		if got := api.QueryAnnIds(AnnQuery{Extra: c.extra}); !equalInts(got, c.want) {
+			t.Fatalf("extra %v: %v, expected %v", c.extra, got, c.want)
+		}
+	}
+}
+
+func Test_objectFields(t *testing.T) {
+	data := " { \"a\" : 1 ,\"b\\u0022\":\"x}\\\"]\",\"c\":{\"d\":[1,{\"e\":\"}\"}]},\"f\":[ ],\"g\":null,\"h\":-1.5e3 } "
+	var keys, values []string
+	if err := objectFields([]byte(data), func(key string, value []byte) error {
+		keys = append(keys, key)
+		values = append(values, string(value))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	wantKeys := []string{"a", `b"`, "c", "f", "g", "h"}
+	wantValues := []string{"1", `"x}\"]"`, `{"d":[1,{"e":"}"}]}`, "[ ]", "null", "-1.5e3"}
+	if !reflect.DeepEqual(keys, wantKeys) || !reflect.DeepEqual(values, wantValues) {
+		t.Fatalf("keys %q values %q", keys, values)
+	}
+	for _, bad := range []string{"", "[]", `{"a"}`, `{"a":}`, `{"a":1`, `{"a":"1}`, `{"a":[1}`, `{"a":1 "b":2}`} {
+		if err := objectFields([]byte(bad), func(string, []byte) error { return nil }); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
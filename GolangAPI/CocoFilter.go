@@ -0,0 +1,604 @@
+package coco
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A small expression language to select annotations and images, e.g.
+//  cat in ("person", "car") and area > 32*32 and not iscrowd and img.width >= 640
+// Operators by increasing precedence:
+//  or, and, not
+//  == != < <= > >= in (a, b, ...) contains
+//  + -, * /, unary -
+// Annotation fields:
+//  id image_id category_id area iscrowd num_keypoints score
+//  cat supercat (names of the category)  caption
+//  bbox.x bbox.y bbox.w bbox.h aspect (bbox.w / bbox.h)
+//  extra.NAME (a field kept in Annotation.Extra)
+// Image fields:
+//  img.id img.width img.height img.file_name img.aspect img.extra.NAME
+// "contains" tests for a substring ignoring case. Numbers, strings and
+// booleans are truthy when they are not 0, "" or false, a missing extra
+// field is null and falsy.
+
+//FilterError is a malformed filter expression, Offset is the byte offset of
+//the offending token
+type FilterError struct {
+	Msg    string
+	Offset int
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("%s at %d", e.Msg, e.Offset)
+}
+
+type filterType int
+
+const (
+	filterAny filterType = iota
+	filterNum
+	filterStr
+	filterBool
+)
+
+func (t filterType) String() string {
+	return [...]string{"value", "number", "string", "boolean"}[t]
+}
+
+type filterEnv struct {
+	api *CocoApi
+	ann *Annotation
+	img *Image
+}
+
+type filterFunc func(e *filterEnv) interface{}
+
+type filterField struct {
+	typ filterType
+	img bool // needs only the image
+	get filterFunc
+}
+
+func numField(f func(e *filterEnv) float64) filterField {
+	return filterField{typ: filterNum, get: func(e *filterEnv) interface{} { return f(e) }}
+}
+
+func imgField(typ filterType, f func(img *Image) interface{}) filterField {
+	return filterField{typ: typ, img: true, get: func(e *filterEnv) interface{} { return f(e.img) }}
+}
+
+var filterFields = map[string]filterField{
+	"id":            numField(func(e *filterEnv) float64 { return float64(e.ann.ID) }),
+	"image_id":      numField(func(e *filterEnv) float64 { return float64(e.ann.ImageID) }),
+	"category_id":   numField(func(e *filterEnv) float64 { return float64(e.ann.CategoryID) }),
+	"area":          numField(func(e *filterEnv) float64 { return float64(e.ann.Area) }),
+	"iscrowd":       numField(func(e *filterEnv) float64 { return float64(e.ann.Iscrowd) }),
+	"num_keypoints": numField(func(e *filterEnv) float64 { return float64(e.ann.NumKeypoints) }),
+	"score":         numField(func(e *filterEnv) float64 { return float64(e.ann.Score) }),
+	"bbox.x":        numField(func(e *filterEnv) float64 { return float64(e.ann.Bbox[0]) }),
+	"bbox.y":        numField(func(e *filterEnv) float64 { return float64(e.ann.Bbox[1]) }),
+	"bbox.w":        numField(func(e *filterEnv) float64 { return float64(e.ann.Bbox[2]) }),
+	"bbox.h":        numField(func(e *filterEnv) float64 { return float64(e.ann.Bbox[3]) }),
+	"aspect": numField(func(e *filterEnv) float64 {
+		if e.ann.Bbox[3] == 0 {
+			return 0
+		}
+		return float64(e.ann.Bbox[2] / e.ann.Bbox[3])
+	}),
+	"cat": {typ: filterStr, get: func(e *filterEnv) interface{} {
+		return e.api.catMap[e.ann.CategoryID].Name
+	}},
+	"supercat": {typ: filterStr, get: func(e *filterEnv) interface{} {
+		return e.api.catMap[e.ann.CategoryID].Supercategory
+	}},
+	"caption": {typ: filterStr, get: func(e *filterEnv) interface{} { return e.ann.Caption }},
+
+	"img.id":        imgField(filterNum, func(img *Image) interface{} { return float64(img.ID) }),
+	"img.width":     imgField(filterNum, func(img *Image) interface{} { return float64(img.Width) }),
+	"img.height":    imgField(filterNum, func(img *Image) interface{} { return float64(img.Height) }),
+	"img.file_name": imgField(filterStr, func(img *Image) interface{} { return img.FileName }),
+	"img.aspect": imgField(filterNum, func(img *Image) interface{} {
+		if img.Height == 0 {
+			return float64(0)
+		}
+		return float64(img.Width) / float64(img.Height)
+	}),
+}
+
+// extraValue decodes an extra field into a filter value.
+func extraValue(extra map[string]json.RawMessage, name string) interface{} {
+	raw, ok := extra[name]
+	if !ok {
+		return nil
+	}
+	var v interface{}
+	if json.Unmarshal(raw, &v) != nil {
+		return nil
+	}
+	switch v.(type) {
+	case float64, string, bool:
+		return v
+	}
+	return nil
+}
+
+func lookupField(name string) (filterField, bool) {
+	if f, ok := filterFields[name]; ok {
+		return f, true
+	}
+	if extra := strings.TrimPrefix(name, "img.extra."); extra != name && extra != "" {
+		return filterField{img: true, get: func(e *filterEnv) interface{} { return extraValue(e.img.Extra, extra) }}, true
+	}
+	if extra := strings.TrimPrefix(name, "extra."); extra != name && extra != "" {
+		return filterField{get: func(e *filterEnv) interface{} { return extraValue(e.ann.Extra, extra) }}, true
+	}
+	return filterField{}, false
+}
+
+type filterToken struct {
+	kind string // "num", "str", "ident", "op", "eof"
+	text string
+	pos  int
+}
+
+func lexFilter(src string) ([]filterToken, error) {
+	var tokens []filterToken
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' ||
+				(src[j] == 'e' || src[j] == 'E') ||
+				(src[j] == '+' || src[j] == '-') && (src[j-1] == 'e' || src[j-1] == 'E')) {
+				j++
+			}
+			if _, err := strconv.ParseFloat(src[i:j], 64); err != nil {
+				return nil, &FilterError{"invalid number " + src[i:j], i}
+			}
+			tokens = append(tokens, filterToken{"num", src[i:j], i})
+			i = j
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(src) && rune(src[j]) != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, &FilterError{"unterminated string", i}
+			}
+			tokens = append(tokens, filterToken{"str", sb.String(), i})
+			i = j + 1
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '.' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, filterToken{"ident", src[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, o := range []string{"==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "(", ")", ","} {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &FilterError{fmt.Sprintf("unexpected character %q", src[i]), i}
+			}
+			tokens = append(tokens, filterToken{"op", op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, filterToken{"eof", "", len(src)}), nil
+}
+
+type filterParser struct {
+	tokens  []filterToken
+	pos     int
+	imgOnly bool
+	// set when the expression reads an annotation field
+	annFields bool
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+// accept consumes the next token when it is the operator or keyword s.
+func (p *filterParser) accept(s string) bool {
+	t := p.peek()
+	if (t.kind == "op" || t.kind == "ident") && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) errorf(t filterToken, format string, args ...interface{}) error {
+	return &FilterError{fmt.Sprintf(format, args...), t.pos}
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterFunc, filterType, error) {
+	left, lt, err := p.parseAnd()
+	if err != nil {
+		return nil, 0, err
+	}
+	for p.accept("or") {
+		right, _, err := p.parseAnd()
+		if err != nil {
+			return nil, 0, err
+		}
+		l := left
+		left, lt = func(e *filterEnv) interface{} { return truthy(l(e)) || truthy(right(e)) }, filterBool
+	}
+	return left, lt, nil
+}
+
+func (p *filterParser) parseAnd() (filterFunc, filterType, error) {
+	left, lt, err := p.parseNot()
+	if err != nil {
+		return nil, 0, err
+	}
+	for p.accept("and") {
+		right, _, err := p.parseNot()
+		if err != nil {
+			return nil, 0, err
+		}
+		l := left
+		left, lt = func(e *filterEnv) interface{} { return truthy(l(e)) && truthy(right(e)) }, filterBool
+	}
+	return left, lt, nil
+}
+
+func (p *filterParser) parseNot() (filterFunc, filterType, error) {
+	if p.accept("not") {
+		arg, _, err := p.parseNot()
+		if err != nil {
+			return nil, 0, err
+		}
+		return func(e *filterEnv) interface{} { return !truthy(arg(e)) }, filterBool, nil
+	}
+	return p.parseCmp()
+}
+
+// compatibleTypes reports whether values of a and b can be compared.
+func compatibleTypes(a, b filterType) bool {
+	return a == filterAny || b == filterAny || a == b
+}
+
+func compareValues(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case bool:
+		if b, ok := b.(bool); ok && a == b {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func (p *filterParser) parseCmp() (filterFunc, filterType, error) {
+	left, lt, err := p.parseSum()
+	if err != nil {
+		return nil, 0, err
+	}
+	t := p.peek()
+	switch {
+	case t.kind == "op" && (t.text == "==" || t.text == "!=" || t.text[0] == '<' || t.text[0] == '>'):
+		p.pos++
+		right, rt, err := p.parseSum()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !compatibleTypes(lt, rt) {
+			return nil, 0, p.errorf(t, "cannot compare %s and %s", lt, rt)
+		}
+		op := t.text
+		return func(e *filterEnv) interface{} {
+			c, ok := compareValues(left(e), right(e))
+			switch op {
+			case "==":
+				return ok && c == 0
+			case "!=":
+				return !ok || c != 0
+			case "<":
+				return ok && c < 0
+			case "<=":
+				return ok && c <= 0
+			case ">":
+				return ok && c > 0
+			}
+			return ok && c >= 0
+		}, filterBool, nil
+	case t.kind == "ident" && t.text == "in":
+		p.pos++
+		if open := p.peek(); !p.accept("(") {
+			return nil, 0, p.errorf(open, "expected ( after in")
+		}
+		var list []filterFunc
+		for {
+			elem := p.peek()
+			f, et, err := p.parseSum()
+			if err != nil {
+				return nil, 0, err
+			}
+			if !compatibleTypes(lt, et) {
+				return nil, 0, p.errorf(elem, "cannot compare %s and %s", lt, et)
+			}
+			list = append(list, f)
+			if p.accept(")") {
+				break
+			}
+			if sep := p.peek(); !p.accept(",") {
+				return nil, 0, p.errorf(sep, "expected , or )")
+			}
+		}
+		return func(e *filterEnv) interface{} {
+			v := left(e)
+			for _, f := range list {
+				if c, ok := compareValues(v, f(e)); ok && c == 0 {
+					return true
+				}
+			}
+			return false
+		}, filterBool, nil
+	case t.kind == "ident" && t.text == "contains":
+		p.pos++
+		rt := p.peek()
+		right, typ, err := p.parseSum()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !compatibleTypes(lt, filterStr) {
+			return nil, 0, p.errorf(t, "contains needs a string, not a %s", lt)
+		}
+		if !compatibleTypes(typ, filterStr) {
+			return nil, 0, p.errorf(rt, "contains needs a string, not a %s", typ)
+		}
+		return func(e *filterEnv) interface{} {
+			a, ok1 := left(e).(string)
+			b, ok2 := right(e).(string)
+			return ok1 && ok2 && strings.Contains(strings.ToLower(a), strings.ToLower(b))
+		}, filterBool, nil
+	}
+	return left, lt, nil
+}
+
+func (p *filterParser) parseSum() (filterFunc, filterType, error) {
+	return p.parseArith(p.parseProduct, "+", "-")
+}
+
+func (p *filterParser) parseProduct() (filterFunc, filterType, error) {
+	return p.parseArith(p.parseUnary, "*", "/")
+}
+
+func (p *filterParser) parseArith(next func() (filterFunc, filterType, error), ops ...string) (filterFunc, filterType, error) {
+	left, lt, err := next()
+	if err != nil {
+		return nil, 0, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != "op" || (t.text != ops[0] && t.text != ops[1]) {
+			return left, lt, nil
+		}
+		p.pos++
+		right, rt, err := next()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !compatibleTypes(lt, filterNum) || !compatibleTypes(rt, filterNum) {
+			return nil, 0, p.errorf(t, "%s needs numbers", t.text)
+		}
+		l, op := left, t.text
+		left, lt = func(e *filterEnv) interface{} {
+			a, ok1 := l(e).(float64)
+			b, ok2 := right(e).(float64)
+			if !ok1 || !ok2 {
+				return nil
+			}
+			switch op {
+			case "+":
+				return a + b
+			case "-":
+				return a - b
+			case "*":
+				return a * b
+			}
+			return a / b
+		}, filterNum
+	}
+}
+
+func (p *filterParser) parseUnary() (filterFunc, filterType, error) {
+	t := p.peek()
+	if p.accept("-") {
+		arg, typ, err := p.parseUnary()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !compatibleTypes(typ, filterNum) {
+			return nil, 0, p.errorf(t, "- needs a number")
+		}
+		return func(e *filterEnv) interface{} {
+			if v, ok := arg(e).(float64); ok {
+				return -v
+			}
+			return nil
+		}, filterNum, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (filterFunc, filterType, error) {
+	t := p.peek()
+	p.pos++
+	switch t.kind {
+	case "num":
+		v, _ := strconv.ParseFloat(t.text, 64)
+		return func(*filterEnv) interface{} { return v }, filterNum, nil
+	case "str":
+		v := t.text
+		return func(*filterEnv) interface{} { return v }, filterStr, nil
+	case "ident":
+		switch t.text {
+		case "true", "false":
+			v := t.text == "true"
+			return func(*filterEnv) interface{} { return v }, filterBool, nil
+		case "and", "or", "not", "in", "contains":
+			return nil, 0, p.errorf(t, "unexpected %s", t.text)
+		}
+		f, ok := lookupField(t.text)
+		if !ok {
+			return nil, 0, p.errorf(t, "unknown field %s", t.text)
+		}
+		if p.imgOnly && !f.img {
+			return nil, 0, p.errorf(t, "%s is not an image field", t.text)
+		}
+		if !f.img {
+			p.annFields = true
+		}
+		return f.get, f.typ, nil
+	case "op":
+		if t.text == "(" {
+			f, typ, err := p.parseOr()
+			if err != nil {
+				return nil, 0, err
+			}
+			if c := p.peek(); !p.accept(")") {
+				return nil, 0, p.errorf(c, "expected )")
+			}
+			return f, typ, nil
+		}
+		return nil, 0, p.errorf(t, "unexpected %s", t.text)
+	}
+	p.pos--
+	return nil, 0, p.errorf(t, "unexpected end of expression")
+}
+
+//Filter is a compiled filter expression
+type Filter struct {
+	src       string
+	fn        filterFunc
+	annFields bool
+}
+
+func parseFilter(src string, imgOnly bool) (*Filter, error) {
+	tokens, err := lexFilter(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens, imgOnly: imgOnly}
+	fn, _, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, p.errorf(t, "unexpected %s", t.text)
+	}
+	return &Filter{src: src, fn: fn, annFields: p.annFields}, nil
+}
+
+//ParseFilter compiles an expression over annotation and image fields,
+//syntax errors are returned as *FilterError
+func ParseFilter(src string) (*Filter, error) {
+	return parseFilter(src, false)
+}
+
+//ParseImageFilter compiles an expression over image fields only
+func ParseImageFilter(src string) (*Filter, error) {
+	return parseFilter(src, true)
+}
+
+//String returns the source of the expression
+func (f *Filter) String() string {
+	return f.src
+}
+
+//MatchAnn evaluates the filter for an annotation of api
+func (f *Filter) MatchAnn(api *CocoApi, ann Annotation) bool {
+	img := api.imgMap[ann.ImageID]
+	return truthy(f.fn(&filterEnv{api: api, ann: &ann, img: &img}))
+}
+
+//MatchImg evaluates a filter for an image. Filters from ParseFilter are
+//accepted as long as they only read image fields, otherwise an error is
+//returned as there is no annotation to evaluate them for.
+func (f *Filter) MatchImg(img Image) (bool, error) {
+	if f.annFields {
+		return false, fmt.Errorf("filter %q reads annotation fields, MatchImg needs an image filter", f.src)
+	}
+	return truthy(f.fn(&filterEnv{img: &img})), nil
+}
+
+//FilterAnnIds Get the ids of the annotations matching the expression, sorted ascending
+func (api *CocoApi) FilterAnnIds(expr string) ([]int, error) {
+	f, err := ParseFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for id, ann := range api.annMap {
+		if f.MatchAnn(api, ann) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+//FilterImgIds Get the ids of the images matching an expression over image
+//fields, sorted ascending
+func (api *CocoApi) FilterImgIds(expr string) ([]int, error) {
+	f, err := ParseImageFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for id, img := range api.imgMap {
+		// ParseImageFilter rejects annotation fields, so there is no error
+		if ok, _ := f.MatchImg(img); ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
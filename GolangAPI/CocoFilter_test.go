@@ -0,0 +1,114 @@
+package coco
+
+import (
+	"errors"
+	"testing"
+)
+
+const filterDataset = `{
+	"images": [
+		{"id": 1, "width": 640, "height": 480, "file_name": "a.jpg"},
+		{"id": 2, "width": 320, "height": 240, "file_name": "b.jpg", "night": true}
+	],
+	"categories": [
+		{"id": 1, "name": "person", "supercategory": "human"},
+		{"id": 2, "name": "car", "supercategory": "vehicle"},
+		{"id": 3, "name": "bus", "supercategory": "vehicle"}
+	],
+	"annotations": [
+		{"id": 1, "image_id": 1, "category_id": 1, "area": 2000, "bbox": [0, 0, 20, 100], "iscrowd": 0, "num_keypoints": 12},
+		{"id": 2, "image_id": 1, "category_id": 2, "area": 500, "bbox": [10, 10, 40, 20], "iscrowd": 0, "occluded": true},
+		{"id": 3, "image_id": 1, "category_id": 1, "area": 9000, "bbox": [50, 50, 90, 100], "iscrowd": 1},
+		{"id": 4, "image_id": 2, "category_id": 3, "area": 3000, "bbox": [0, 0, 100, 30], "iscrowd": 0, "caption": "A red Bus"},
+		{"id": 5, "image_id": 2, "category_id": 2, "area": 1100, "bbox": [5, 5, 50, 22], "iscrowd": 0, "occluded": false}
+	]
+}`
+
+func Test_FilterAnnIds(t *testing.T) {
+	api, err := NewCocoApi([]byte(filterDataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		expr string
+		want []int
+	}{
+		{`cat in ("person", "car") and area > 32*32 and not iscrowd and img.width >= 640`, []int{1}},
+		{`supercat == "vehicle"`, []int{2, 4, 5}},
+		{`bbox.w > 2 * bbox.h or num_keypoints >= 10`, []int{1, 4, 5}},
+		{`aspect < 1`, []int{1, 3}},
+		{`caption contains "red bus"`, []int{4}},
+		{`extra.occluded`, []int{2}},
+		{`extra.occluded == false`, []int{5}},
+		{`not extra.occluded and category_id != 1`, []int{4, 5}},
+		{`img.extra.night and -area < -1000`, []int{4, 5}},
+		{`(iscrowd or image_id == 2) and id != 4`, []int{3, 5}},
+		{`img.file_name == 'b.jpg' and area / 100 <= 11`, []int{5}},
+		{`false`, []int{}},
+	}
+	for _, c := range cases {
+		got, err := api.FilterAnnIds(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		if !equalInts(got, c.want) {
+			t.Errorf("%s: got %v, expected %v", c.expr, got, c.want)
+		}
+	}
+
+	imgs, err := api.FilterImgIds(`img.width * img.height > 100000 or img.extra.night`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalInts(imgs, []int{1, 2}) {
+		t.Errorf("images %v", imgs)
+	}
+	if imgs, _ := api.FilterImgIds(`img.aspect > 1.3 and img.id == 2`); !equalInts(imgs, []int{2}) {
+		t.Errorf("images %v", imgs)
+	}
+}
+
+func Test_FilterErrors(t *testing.T) {
+	cases := []struct {
+		expr   string
+		offset int
+	}{
+		{`area >`, 6},
+		{`area > 10 and`, 13},
+		{`cat == "person`, 7},
+		{`colour == "red"`, 0},
+		{`cat > 3`, 4},
+		{`area + "x" > 1`, 5},
+		{`cat in "person"`, 7},
+		{`cat in ("a" "b")`, 12},
+		{`(area > 1`, 9},
+		{`area > 1 )`, 9},
+		{`area # 1`, 5},
+		{`area contains "x"`, 5},
+		{`1.2.3 > area`, 0},
+	}
+	for _, c := range cases {
+		_, err := ParseFilter(c.expr)
+		var fe *FilterError
+		if !errors.As(err, &fe) {
+			t.Fatalf("%s: expected a FilterError, got %v", c.expr, err)
+		}
+		if fe.Offset != c.offset {
+			t.Errorf("%s: error %q, expected offset %d", c.expr, fe, c.offset)
+		}
+	}
+
+	_, err := ParseImageFilter(`img.width > 10 and area > 5`)
+	if fe, ok := err.(*FilterError); !ok || fe.Offset != 19 {
+		t.Errorf("image filter with annotation field: %v", err)
+	}
+
+	f, _ := ParseFilter(`img.width > 10`)
+	if ok, err := f.MatchImg(Image{Width: 20}); !ok || err != nil {
+		t.Errorf("image-only expression from ParseFilter: %v, %v", ok, err)
+	}
+	f, _ = ParseFilter(`img.width > 10 and area > 5`)
+	if _, err := f.MatchImg(Image{Width: 20}); err == nil {
+		t.Error("expected MatchImg to reject an annotation filter")
+	}
+}
@@ -0,0 +1,57 @@
+package coco
+
+// Accessors of the reverse indexes built by CocoApi. The returned lists are
+// sorted copies, the counts are answered without copying.
+//  ImgToAnns/CatToAnns - Ann ids of an image/category.
+//  ImgToCats/CatToImgs - Cat ids present in an image/img ids containing a category.
+//  CatByName           - Category with the given name.
+
+func copyIds(ids []int) []int {
+	return append([]int{}, ids...)
+}
+
+//ImgToAnns Get the ids of the annotations of an image
+func (api *CocoApi) ImgToAnns(imgId int) []int {
+	return copyIds(api.imgToAnnMap[imgId])
+}
+
+//CatToAnns Get the ids of the annotations of a category
+func (api *CocoApi) CatToAnns(catId int) []int {
+	return copyIds(api.catToAnnMap[catId])
+}
+
+//ImgToCats Get the ids of the categories annotated in an image
+func (api *CocoApi) ImgToCats(imgId int) []int {
+	return copyIds(api.imgToCatMap[imgId])
+}
+
+//CatToImgs Get the ids of the images a category is annotated in
+func (api *CocoApi) CatToImgs(catId int) []int {
+	return copyIds(api.catToImgMap[catId])
+}
+
+//CatByName Get the category with the given name
+func (api *CocoApi) CatByName(name string) (Categories, bool) {
+	cat, ok := api.catNameMap[name]
+	return cat, ok
+}
+
+//ImgAnnCount Get the number of annotations of an image
+func (api *CocoApi) ImgAnnCount(imgId int) int {
+	return len(api.imgToAnnMap[imgId])
+}
+
+//CatAnnCount Get the number of annotations of a category
+func (api *CocoApi) CatAnnCount(catId int) int {
+	return len(api.catToAnnMap[catId])
+}
+
+//ImgCatCount Get the number of categories annotated in an image
+func (api *CocoApi) ImgCatCount(imgId int) int {
+	return len(api.imgToCatMap[imgId])
+}
+
+//CatImgCount Get the number of images a category is annotated in
+func (api *CocoApi) CatImgCount(catId int) int {
+	return len(api.catToImgMap[catId])
+}
@@ -0,0 +1,73 @@
+package coco
+
+import (
+	"sort"
+	"testing"
+)
+
+func Test_ReverseIndexes(t *testing.T) {
+	api := datasetMetaObj
+	imgAnns := make(map[int][]int)
+	catAnns := make(map[int][]int)
+	imgCats := make(map[int]map[int]bool)
+	catImgs := make(map[int]map[int]bool)
+	for id, ann := range api.annMap {
+		imgAnns[ann.ImageID] = append(imgAnns[ann.ImageID], id)
+		catAnns[ann.CategoryID] = append(catAnns[ann.CategoryID], id)
+		if imgCats[ann.ImageID] == nil {
+			imgCats[ann.ImageID] = make(map[int]bool)
+		}
+		imgCats[ann.ImageID][ann.CategoryID] = true
+		if catImgs[ann.CategoryID] == nil {
+			catImgs[ann.CategoryID] = make(map[int]bool)
+		}
+		catImgs[ann.CategoryID][ann.ImageID] = true
+	}
+	keys := func(m map[int]bool) []int {
+		ids := []int{}
+		for id := range m {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		return ids
+	}
+	sorted := func(ids []int) []int {
+		ids = append([]int{}, ids...)
+		sort.Ints(ids)
+		return ids
+	}
+
+	for _, img := range api.GetImgIds(nil) {
+		if got := api.ImgToAnns(img); !equalInts(got, sorted(imgAnns[img])) || api.ImgAnnCount(img) != len(got) {
+			t.Fatalf("image %d anns %v", img, got)
+		}
+		if got := api.ImgToCats(img); !equalInts(got, keys(imgCats[img])) || api.ImgCatCount(img) != len(got) {
+			t.Fatalf("image %d cats %v", img, got)
+		}
+	}
+	for _, cat := range api.GetCatIds(nil, nil) {
+		if got := api.CatToAnns(cat); !equalInts(got, sorted(catAnns[cat])) || api.CatAnnCount(cat) != len(got) {
+			t.Fatalf("category %d anns %v", cat, got)
+		}
+		if got := api.CatToImgs(cat); !equalInts(got, keys(catImgs[cat])) || api.CatImgCount(cat) != len(got) {
+			t.Fatalf("category %d imgs %v", cat, got)
+		}
+		c := api.catMap[cat]
+		if byName, ok := api.CatByName(c.Name); !ok || byName.Name != c.Name {
+			t.Fatalf("category %q not found by name", c.Name)
+		}
+	}
+	if _, ok := api.CatByName("no such category"); ok {
+		t.Fatal("found an unknown category")
+	}
+
+	// accessors return copies
+	img := api.GetImgIds(nil)[0]
+	anns := api.ImgToAnns(img)
+	if len(anns) > 0 {
+		anns[0] = -1
+		if api.ImgToAnns(img)[0] == -1 {
+			t.Fatal("ImgToAnns exposes the index")
+		}
+	}
+}
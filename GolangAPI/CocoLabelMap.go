@@ -0,0 +1,280 @@
+package coco
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+)
+
+// Semantic label maps, the per-pixel category id images used for semantic
+// segmentation training, and their conversion from and to annotations.
+//  RenderLabelMap        - Paint all annotations of an image into a LabelMap.
+//  DecodeLabelMap        - Read a uint8 or uint16 label map PNG.
+//  LabelMapToAnnotations - Convert a label map into one RLE annotation per category.
+
+//LabelMap is a row-major category id image
+type LabelMap struct {
+	Width  int
+	Height int
+	Pix    []uint16
+}
+
+//PaintOrder is the occlusion order used by RenderLabelMap, annotations
+//painted later cover the ones painted before
+type PaintOrder int
+
+const (
+	//PaintByArea paints large annotations first so that small ones stay visible
+	PaintByArea PaintOrder = iota
+	//PaintByID paints annotations in ascending id order
+	PaintByID
+)
+
+//LabelMapOptions controls RenderLabelMap
+type LabelMapOptions struct {
+	// value of pixels not covered by any annotation
+	Background uint16
+	// value painted for crowd annotations when CrowdAsIgnore is set
+	Ignore        uint16
+	CrowdAsIgnore bool
+	Order         PaintOrder
+	// optional category id to label value table, categories missing from
+	// a non-nil table are not painted
+	Labels map[int]uint16
+}
+
+//NewLabelMap returns a label map filled with value
+func NewLabelMap(width, height int, value uint16) *LabelMap {
+	lm := &LabelMap{Width: width, Height: height, Pix: make([]uint16, width*height)}
+	if value != 0 {
+		for i := range lm.Pix {
+			lm.Pix[i] = value
+		}
+	}
+	return lm
+}
+
+// paint sets every pixel of the column-major spans to value.
+func (lm *LabelMap) paint(cols [][]span, value uint16) {
+	for x := 0; x < len(cols) && x < lm.Width; x++ {
+		for _, s := range cols[x] {
+			for y := int(s.start); y < int(s.end) && y < lm.Height; y++ {
+				lm.Pix[y*lm.Width+x] = value
+			}
+		}
+	}
+}
+
+//RenderLabelMap paints all annotations of an image into a label map of the
+//image size, see LabelMapOptions for the occlusion order and ignore value
+func (api *CocoApi) RenderLabelMap(imgId int, opts LabelMapOptions) (*LabelMap, error) {
+	img, ok := api.imgMap[imgId]
+	if !ok {
+		return nil, errors.New("image not found")
+	}
+	h, w := uint32(img.Height), uint32(img.Width)
+	anns := api.LoadAnns(api.imgToAnnMap[imgId])
+	switch opts.Order {
+	case PaintByID:
+		sort.SliceStable(anns, func(i, j int) bool { return anns[i].ID < anns[j].ID })
+	default:
+		sort.SliceStable(anns, func(i, j int) bool {
+			if anns[i].Area != anns[j].Area {
+				return anns[i].Area > anns[j].Area
+			}
+			return anns[i].ID < anns[j].ID
+		})
+	}
+
+	lm := NewLabelMap(img.Width, img.Height, opts.Background)
+	for _, ann := range anns {
+		if ann.Segmentation.SegmentationHelper == nil {
+			continue
+		}
+		var value uint16
+		if opts.Labels != nil {
+			v, ok := opts.Labels[ann.CategoryID]
+			if !ok {
+				continue
+			}
+			value = v
+		} else if ann.CategoryID < 0 || ann.CategoryID > math.MaxUint16 {
+			return nil, fmt.Errorf("category id %d does not fit into a label map, use Labels", ann.CategoryID)
+		} else {
+			value = uint16(ann.CategoryID)
+		}
+		if ann.Iscrowd == 1 && opts.CrowdAsIgnore {
+			value = opts.Ignore
+		}
+		cnts, err := rasterizeSegment(ann.Segmentation.SegmentationHelper, h, w)
+		if err != nil {
+			return nil, err
+		}
+		lm.paint(countsToColumns(cnts, h, w), value)
+	}
+	return lm, nil
+}
+
+//Image returns the label map as *image.Gray when all values fit into a byte
+//and as *image.Gray16 otherwise
+func (lm *LabelMap) Image() image.Image {
+	wide := false
+	for _, v := range lm.Pix {
+		if v > 255 {
+			wide = true
+			break
+		}
+	}
+	rect := image.Rect(0, 0, lm.Width, lm.Height)
+	if !wide {
+		img := image.NewGray(rect)
+		for i, v := range lm.Pix {
+			img.Pix[i] = uint8(v)
+		}
+		return img
+	}
+	img := image.NewGray16(rect)
+	for i, v := range lm.Pix {
+		img.Pix[2*i] = uint8(v >> 8)
+		img.Pix[2*i+1] = uint8(v)
+	}
+	return img
+}
+
+//EncodePNG writes the label map as an 8 or 16 bit gray PNG
+func (lm *LabelMap) EncodePNG(w io.Writer) error {
+	return png.Encode(w, lm.Image())
+}
+
+//DecodeLabelMap reads a label map PNG. Gray and paletted images give the
+//pixel value (the palette index for paletted images), 16 bit gray images
+//give the full 16 bit value, color images return an error.
+func DecodeLabelMap(r io.Reader) (*LabelMap, error) {
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	switch img.(type) {
+	case *image.Gray, *image.Gray16, *image.Paletted:
+	default:
+		// converting colors to gray would give wrong label values
+		return nil, fmt.Errorf("label map is a %T, want a gray or paletted image", img)
+	}
+	b := img.Bounds()
+	lm := NewLabelMap(b.Dx(), b.Dy(), 0)
+	for y := 0; y < lm.Height; y++ {
+		for x := 0; x < lm.Width; x++ {
+			var v uint16
+			switch src := img.(type) {
+			case *image.Gray:
+				v = uint16(src.GrayAt(b.Min.X+x, b.Min.Y+y).Y)
+			case *image.Gray16:
+				v = src.Gray16At(b.Min.X+x, b.Min.Y+y).Y
+			case *image.Paletted:
+				v = uint16(src.ColorIndexAt(b.Min.X+x, b.Min.Y+y))
+			}
+			lm.Pix[y*lm.Width+x] = v
+		}
+	}
+	return lm, nil
+}
+
+// scanColumns splits an id image into column-major foreground spans per id.
+func scanColumns(width, height int, at func(x, y int) uint32) map[uint32][][]span {
+	parts := make(map[uint32][][]span)
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; {
+			v := at(x, y)
+			start := y
+			for y < height && at(x, y) == v {
+				y++
+			}
+			cols, ok := parts[v]
+			if !ok {
+				cols = make([][]span, width)
+				parts[v] = cols
+			}
+			cols[x] = append(cols[x], span{uint32(start), uint32(y)})
+		}
+	}
+	return parts
+}
+
+//LabelImportOptions controls LabelMapToAnnotations
+type LabelImportOptions struct {
+	// label values that are not converted, e.g. 0 for unlabeled and 255 for ignore
+	Skip []uint16
+	// optional label value to category id table, labels missing from a
+	// non-nil table are not converted. Without a table the label value is
+	// used as category id.
+	Categories map[uint16]int
+	// id of the first annotation, the following ones are numbered
+	// consecutively. 0 leaves the ids unset as in result files.
+	FirstID int
+}
+
+//LabelMapToAnnotations converts a label map into one SegmentationRLE
+//annotation per category with Area and Bbox set, ordered by category id
+func LabelMapToAnnotations(lm *LabelMap, imageID int, opts LabelImportOptions) []Annotation {
+	skip := make(map[uint16]bool)
+	for _, v := range opts.Skip {
+		skip[v] = true
+	}
+	h, w := uint32(lm.Height), uint32(lm.Width)
+	parts := scanColumns(lm.Width, lm.Height, func(x, y int) uint32 {
+		return uint32(lm.Pix[y*lm.Width+x])
+	})
+
+	type labeled struct {
+		catId int
+		cols  [][]span
+	}
+	var list []labeled
+	for v, cols := range parts {
+		if skip[uint16(v)] {
+			continue
+		}
+		catId := int(v)
+		if opts.Categories != nil {
+			id, ok := opts.Categories[uint16(v)]
+			if !ok {
+				continue
+			}
+			catId = id
+		}
+		list = append(list, labeled{catId, cols})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].catId < list[j].catId })
+
+	// several labels may map to the same category
+	var anns []Annotation
+	for i := 0; i < len(list); {
+		cols := list[i].cols
+		j := i + 1
+		for ; j < len(list) && list[j].catId == list[i].catId; j++ {
+			for x := range cols {
+				cols[x] = unionSpans(append(cols[x], list[j].cols[x]...))
+			}
+		}
+		ann := Annotation{
+			ImageID:    imageID,
+			CategoryID: list[i].catId,
+			Segmentation: Segment{&SegmentationRLE{
+				Counts: countsToString(columnsToCounts(cols, h, w)),
+				Size:   [2]uint32{h, w},
+			}},
+			Area: float32(columnsArea(cols)),
+			Bbox: columnsBbox(cols),
+		}
+		if opts.FirstID > 0 {
+			ann.ID = opts.FirstID + len(anns)
+		}
+		anns = append(anns, ann)
+		i = j
+	}
+	return anns
+}
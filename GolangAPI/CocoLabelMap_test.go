@@ -0,0 +1,97 @@
+package coco
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func Test_LabelMapRoundTrip(t *testing.T) {
+	api, err := NewCocoApi(datasetMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, imgId := range api.GetImgIds(nil) {
+		lm, err := api.RenderLabelMap(imgId, LabelMapOptions{Order: PaintByID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := lm.EncodePNG(&buf); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeLabelMap(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		anns := LabelMapToAnnotations(decoded, imgId, LabelImportOptions{Skip: []uint16{0}, FirstID: 1})
+		byCat := make(map[int]Annotation)
+		for _, ann := range anns {
+			rle, _ := NewMaskRLE(ann.Segmentation.SegmentationHelper)
+			if float32(rle.Area()) != ann.Area || rle.Bbox() != ann.Bbox {
+				t.Fatalf("image %d cat %d: area/bbox do not match the segmentation", imgId, ann.CategoryID)
+			}
+			byCat[ann.CategoryID] = ann
+		}
+		// the stuff annotations of the test dataset do not overlap
+		for _, orig := range api.LoadAnns(api.GetAnnIds([]int{imgId}, nil, nil, 3)) {
+			got, ok := byCat[orig.CategoryID]
+			if !ok {
+				t.Fatalf("image %d: category %d missing after import", imgId, orig.CategoryID)
+			}
+			a := DecodeSegmentToMask(orig.Segmentation.SegmentationHelper)
+			b := DecodeSegmentToMask(got.Segmentation.SegmentationHelper)
+			if !bytes.Equal(a, b) {
+				t.Fatalf("image %d: category %d mask differs after round trip", imgId, orig.CategoryID)
+			}
+		}
+	}
+}
+
+func Test_LabelMapWide(t *testing.T) {
+	lm := NewLabelMap(3, 2, 0)
+	lm.Pix = []uint16{0, 300, 300, 7, 7, 65535}
+	var buf bytes.Buffer
+	if err := lm.EncodePNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeLabelMap(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range lm.Pix {
+		if decoded.Pix[i] != lm.Pix[i] {
+			t.Fatalf("pixel %d: %d != %d", i, decoded.Pix[i], lm.Pix[i])
+		}
+	}
+	anns := LabelMapToAnnotations(decoded, 1, LabelImportOptions{Skip: []uint16{0, 65535}, Categories: map[uint16]int{300: 5, 7: 5}})
+	if len(anns) != 1 || anns[0].CategoryID != 5 || anns[0].Area != 4 {
+		t.Fatalf("unexpected annotations: %+v", anns)
+	}
+}
+
+func Test_LabelMapErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeLabelMap(&buf); err == nil {
+		t.Fatal("expected an error for a color label map")
+	}
+
+	api, err := NewCocoApi([]byte(`{
+		"images": [{"id": 1, "width": 4, "height": 4}],
+		"categories": [{"id": 70000, "name": "a"}],
+		"annotations": [{"id": 1, "image_id": 1, "category_id": 70000, "segmentation": [[0, 0, 2, 0, 2, 2]]}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.RenderLabelMap(1, LabelMapOptions{}); err == nil {
+		t.Fatal("expected an error for a category id above 65535")
+	}
+	if _, err := api.RenderLabelMap(1, LabelMapOptions{Labels: map[int]uint16{70000: 1}}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,244 @@
+package coco
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+// Merging datasets. Categories are unified by name and licenses by name and
+// url, every id keeps its value unless an earlier dataset already used it,
+// then it gets the next id above all ids used so far.
+
+//DuplicatePolicy decides what Merge does with an image that was already
+//merged from an earlier dataset
+type DuplicatePolicy int
+
+const (
+	// DuplicateSkip drops the later copy and its annotations
+	DuplicateSkip DuplicatePolicy = iota
+	// DuplicateMerge moves the annotations of the later copy to the first one
+	DuplicateMerge
+	// DuplicateKeep keeps both copies as separate images
+	DuplicateKeep
+)
+
+//MergeOptions configures Merge
+type MergeOptions struct {
+	// renames categories before they are unified, e.g. {"automobile": "car"}
+	CategoryMap map[string]string
+	// an extra image field holding a content hash, e.g. "sha1". When set
+	// duplicate images are found by hash, otherwise by file_name. Images
+	// without a hash or file_name are never duplicates.
+	HashField  string
+	Duplicates DuplicatePolicy
+}
+
+//IdRemap is an id of a source dataset that changed in the merged dataset
+type IdRemap struct {
+	Source   int
+	Old, New int
+}
+
+//CategoryMismatch is a category unified by name with an earlier category
+//that differs in other fields, the earlier category is kept
+type CategoryMismatch struct {
+	Source int
+	ID     int
+	Name   string
+	// json names of the differing fields
+	Fields []string
+}
+
+//DuplicateImage is an image of a source dataset that was already merged
+type DuplicateImage struct {
+	Source  int
+	ImageID int
+	// id of the first copy in the merged dataset
+	MergedID int
+	// whether width and height agree with the first copy
+	SameSize bool
+	// source ids of the annotations of the duplicate
+	Annotations []int
+}
+
+//MergeReport lists what Merge changed
+type MergeReport struct {
+	Images, Annotations, Categories, Licenses []IdRemap
+	CategoryMismatches                        []CategoryMismatch
+	Duplicates                                []DuplicateImage
+}
+
+type idAlloc struct {
+	used map[int]bool
+	last int
+}
+
+// take returns id when it is free and the next unused id otherwise.
+func (a *idAlloc) take(id int) int {
+	if a.used == nil {
+		a.used = make(map[int]bool)
+	}
+	if id == 0 || a.used[id] {
+		id = a.last + 1
+	}
+	a.used[id] = true
+	a.last = maxInt(a.last, id)
+	return id
+}
+
+// categoryDiff lists the json names of the fields other than id and name
+// in which a and b differ.
+func categoryDiff(a, b Categories) []string {
+	var fields []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if name == "" || name == "id" || name == "name" {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// imageKey identifies an image for duplicate detection, "" for none.
+func imageKey(img Image, hashField string) string {
+	if hashField == "" {
+		return img.FileName
+	}
+	var hash string
+	if json.Unmarshal(img.Extra[hashField], &hash) != nil {
+		return ""
+	}
+	return hash
+}
+
+//Merge combines several datasets into one, the info and extra fields are
+//taken from the first dataset. Annotations of images or categories that a
+//source does not contain keep those ids.
+func Merge(apis []*CocoApi, opts MergeOptions) (CocoData, MergeReport, error) {
+	var out CocoData
+	var report MergeReport
+	var imgIds, annIds, catIds, licIds idAlloc
+	catByName := make(map[string]int)
+	licByKey := make(map[[2]string]int)
+	imgByKey := make(map[string]int)
+	for s, api := range apis {
+		if api == nil {
+			return CocoData{}, MergeReport{}, errors.New("nil dataset")
+		}
+		data := api.Dataset()
+		if s == 0 {
+			out.Info, out.Extra = data.Info, data.Extra
+		}
+		remap := func(list *[]IdRemap, old, id int) {
+			if old != id {
+				*list = append(*list, IdRemap{s, old, id})
+			}
+		}
+
+		licMap := make(map[int]int)
+		for _, lic := range data.Licenses {
+			old := lic.ID
+			key := [2]string{lic.Name, lic.URL}
+			i, ok := licByKey[key]
+			if !ok {
+				i = len(out.Licenses)
+				licByKey[key] = i
+				lic.ID = licIds.take(old)
+				out.Licenses = append(out.Licenses, lic)
+			}
+			licMap[old] = out.Licenses[i].ID
+			remap(&report.Licenses, old, licMap[old])
+		}
+
+		catMap := make(map[int]int)
+		for _, cat := range data.Categories {
+			old := cat.ID
+			if name, ok := opts.CategoryMap[cat.Name]; ok {
+				cat.Name = name
+			}
+			if i, ok := catByName[cat.Name]; ok {
+				first := out.Categories[i]
+				if fields := categoryDiff(first, cat); len(fields) > 0 {
+					report.CategoryMismatches = append(report.CategoryMismatches, CategoryMismatch{s, old, cat.Name, fields})
+				}
+				catMap[old] = first.ID
+			} else {
+				catByName[cat.Name] = len(out.Categories)
+				cat.ID = catIds.take(old)
+				out.Categories = append(out.Categories, cat)
+				catMap[old] = cat.ID
+			}
+			remap(&report.Categories, old, catMap[old])
+		}
+		mapCat := func(id int) int {
+			if n, ok := catMap[id]; ok {
+				return n
+			}
+			return id
+		}
+
+		// merged image id of every source image, 0 for dropped images
+		imgMap := make(map[int]int)
+		for _, img := range data.Images {
+			old := img.ID
+			key := imageKey(img, opts.HashField)
+			if i, ok := imgByKey[key]; ok && key != "" {
+				first := out.Images[i]
+				report.Duplicates = append(report.Duplicates, DuplicateImage{
+					Source:      s,
+					ImageID:     old,
+					MergedID:    first.ID,
+					SameSize:    first.Width == img.Width && first.Height == img.Height,
+					Annotations: api.ImgToAnns(old),
+				})
+				switch opts.Duplicates {
+				case DuplicateSkip:
+					imgMap[old] = 0
+					continue
+				case DuplicateMerge:
+					imgMap[old] = first.ID
+					continue
+				}
+			} else if key != "" {
+				imgByKey[key] = len(out.Images)
+			}
+			img.ID = imgIds.take(old)
+			if id, ok := licMap[img.License]; ok {
+				img.License = id
+			}
+			out.Images = append(out.Images, img)
+			imgMap[old] = img.ID
+			remap(&report.Images, old, img.ID)
+		}
+
+		for _, ann := range data.Annotations {
+			imgId, ok := imgMap[ann.ImageID]
+			if ok && imgId == 0 {
+				continue
+			}
+			if ok {
+				ann.ImageID = imgId
+			}
+			old := ann.ID
+			ann.ID = annIds.take(old)
+			remap(&report.Annotations, old, ann.ID)
+			ann.CategoryID = mapCat(ann.CategoryID)
+			if len(ann.SegmentsInfo) > 0 {
+				infos := make([]PSSegmentInfo, len(ann.SegmentsInfo))
+				for i, info := range ann.SegmentsInfo {
+					info.CategoryID = mapCat(info.CategoryID)
+					infos[i] = info
+				}
+				ann.SegmentsInfo = infos
+			}
+			out.Annotations = append(out.Annotations, ann)
+		}
+	}
+	return out, report, nil
+}
@@ -0,0 +1,155 @@
+package coco
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const mergeDatasetA = `{
+	"info": {"description": "vendor a"},
+	"licenses": [{"id": 1, "name": "cc-by", "url": "u1"}],
+	"images": [
+		{"id": 1, "width": 100, "height": 100, "file_name": "x.jpg", "license": 1, "sha1": "aa"},
+		{"id": 2, "width": 100, "height": 100, "file_name": "y.jpg", "license": 1, "sha1": "bb"}
+	],
+	"categories": [
+		{"id": 1, "name": "person", "supercategory": "human"},
+		{"id": 2, "name": "car", "supercategory": "vehicle"}
+	],
+	"annotations": [
+		{"id": 1, "image_id": 1, "category_id": 1, "bbox": [0, 0, 5, 5]},
+		{"id": 2, "image_id": 2, "category_id": 2, "bbox": [1, 1, 5, 5]}
+	]
+}`
+
+const mergeDatasetB = `{
+	"info": {"description": "vendor b"},
+	"licenses": [{"id": 1, "name": "cc-by-sa", "url": "u2"}, {"id": 2, "name": "cc-by", "url": "u1"}],
+	"images": [
+		{"id": 1, "width": 100, "height": 100, "file_name": "z.jpg", "license": 2, "sha1": "aa"},
+		{"id": 5, "width": 50, "height": 50, "file_name": "y.jpg", "license": 1}
+	],
+	"categories": [
+		{"id": 1, "name": "automobile", "supercategory": "vehicle"},
+		{"id": 3, "name": "person", "supercategory": "people"},
+		{"id": 4, "name": "dog", "supercategory": "animal"}
+	],
+	"annotations": [
+		{"id": 1, "image_id": 1, "category_id": 1, "bbox": [2, 2, 5, 5]},
+		{"id": 3, "image_id": 5, "category_id": 3, "bbox": [3, 3, 5, 5]},
+		{"id": 4, "image_id": 5, "category_id": 4, "bbox": [4, 4, 5, 5], "segments_info": [{"id": 7, "category_id": 1}]}
+	]
+}`
+
+func mergeApis(t *testing.T) []*CocoApi {
+	var apis []*CocoApi
+	for _, data := range []string{mergeDatasetA, mergeDatasetB} {
+		api, err := NewCocoApi([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		apis = append(apis, api)
+	}
+	return apis
+}
+
+func Test_Merge(t *testing.T) {
+	apis := mergeApis(t)
+	out, report, err := Merge(apis, MergeOptions{CategoryMap: map[string]string{"automobile": "car"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Info.Description != "vendor a" {
+		t.Errorf("info %+v", out.Info)
+	}
+
+	// y.jpg of b is a duplicate by file name and dropped with its annotations
+	names := func(imgs []Image) []string {
+		var out []string
+		for _, img := range imgs {
+			out = append(out, img.FileName)
+		}
+		return out
+	}
+	if got := names(out.Images); !reflect.DeepEqual(got, []string{"x.jpg", "y.jpg", "z.jpg"}) {
+		t.Fatalf("images %v", got)
+	}
+	want := []DuplicateImage{{Source: 1, ImageID: 5, MergedID: 2, SameSize: false, Annotations: []int{3, 4}}}
+	if !reflect.DeepEqual(report.Duplicates, want) {
+		t.Errorf("duplicates %+v", report.Duplicates)
+	}
+	if want := []IdRemap{{1, 1, 3}}; !reflect.DeepEqual(report.Images, want) {
+		t.Errorf("image remaps %+v", report.Images)
+	}
+	if want := []IdRemap{{1, 1, 2}, {1, 3, 1}}; !reflect.DeepEqual(report.Categories, want) {
+		t.Errorf("category remaps %+v", report.Categories)
+	}
+	if want := []IdRemap{{1, 1, 2}, {1, 2, 1}}; !reflect.DeepEqual(report.Licenses, want) {
+		t.Errorf("license remaps %+v", report.Licenses)
+	}
+	if want := []CategoryMismatch{{1, 3, "person", []string{"supercategory"}}}; !reflect.DeepEqual(report.CategoryMismatches, want) {
+		t.Errorf("mismatches %+v", report.CategoryMismatches)
+	}
+	if len(out.Categories) != 3 || out.Categories[2].Name != "dog" || out.Categories[2].ID != 4 {
+		t.Errorf("categories %+v", out.Categories)
+	}
+	if out.Images[2].License != 1 {
+		t.Errorf("license of z.jpg %d", out.Images[2].License)
+	}
+	if len(out.Annotations) != 3 {
+		t.Fatalf("annotations %+v", out.Annotations)
+	}
+	if ann := out.Annotations[2]; ann.ID != 3 || ann.ImageID != 3 || ann.CategoryID != 2 {
+		t.Errorf("annotation of z.jpg %+v", ann)
+	}
+
+	// the merged dataset loads and is consistent
+	data, err := json.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := NewCocoApi(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := merged.GetImgIds(merged.GetCatIds([]string{"car"}, nil)); !equalInts(ids, []int{2, 3}) {
+		t.Errorf("images with cars %v", ids)
+	}
+}
+
+func Test_MergeDuplicates(t *testing.T) {
+	apis := mergeApis(t)
+	out, report, err := Merge(apis, MergeOptions{Duplicates: DuplicateMerge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Images) != 3 || len(out.Annotations) != 5 {
+		t.Fatalf("%d images %d annotations", len(out.Images), len(out.Annotations))
+	}
+	// the annotations of the copy move to the first y.jpg, with their
+	// categories and panoptic segments remapped
+	last := out.Annotations[4]
+	if last.ImageID != 2 || last.CategoryID != 4 || last.SegmentsInfo[0].CategoryID != 3 {
+		t.Errorf("moved annotation %+v", last)
+	}
+	if len(out.Categories) != 4 {
+		t.Errorf("categories %+v", out.Categories)
+	}
+	if apis[1].annMap[4].SegmentsInfo[0].CategoryID != 1 {
+		t.Error("source dataset changed")
+	}
+
+	// by hash z.jpg is a copy of x.jpg and y.jpg of b has no hash
+	out, report, err = Merge(apis, MergeOptions{HashField: "sha1", Duplicates: DuplicateKeep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Images) != 4 || len(report.Duplicates) != 1 || report.Duplicates[0].ImageID != 1 || report.Duplicates[0].MergedID != 1 {
+		t.Errorf("duplicates by hash %+v", report.Duplicates)
+	}
+
+	if _, _, err := Merge([]*CocoApi{apis[0], nil}, MergeOptions{}); err == nil {
+		t.Error("expected an error for a nil dataset")
+	}
+}
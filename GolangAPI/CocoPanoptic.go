@@ -0,0 +1,170 @@
+package coco
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"sort"
+)
+
+// Panoptic segmentation PNGs. Every pixel stores the id of the segment it
+// belongs to as id = R + 256*G + 256^2*B, id 0 is void. The segments are
+// described by PSSegmentInfo in the SegmentsInfo of the annotation.
+//  DecodePanopticPNG  - Decode a panoptic PNG into one RLE per segment id.
+//  DecodePanoptic     - Decode the PNG of an annotation and check its SegmentsInfo.
+//  EncodePanopticPNG  - Paint segments back into a panoptic PNG.
+//  NewPSSegmentInfo   - Build a PSSegmentInfo with Area and Bbox from a RLE.
+
+//PanopticMismatch is a difference between the PNG and the SegmentsInfo of a
+//panoptic annotation
+type PanopticMismatch struct {
+	SegmentID int
+	Reason    string
+}
+
+func (m PanopticMismatch) String() string {
+	return fmt.Sprintf("segment %d: %s", m.SegmentID, m.Reason)
+}
+
+//RGBToPanopticID converts a panoptic PNG color into a segment id
+func RGBToPanopticID(r, g, b uint8) int {
+	return int(r) + 256*int(g) + 256*256*int(b)
+}
+
+//PanopticIDToRGB converts a segment id into a panoptic PNG color
+func PanopticIDToRGB(id int) (r, g, b uint8) {
+	return uint8(id % 256), uint8(id / 256 % 256), uint8(id / (256 * 256) % 256)
+}
+
+//DecodePanopticPNG decodes a panoptic PNG into one RLE per segment id,
+//void pixels (id 0) are not returned
+func DecodePanopticPNG(r io.Reader) (map[int]MaskRLE, error) {
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	var at func(x, y int) uint32
+	switch src := img.(type) {
+	case *image.RGBA:
+		at = func(x, y int) uint32 {
+			p := src.PixOffset(b.Min.X+x, b.Min.Y+y)
+			return uint32(RGBToPanopticID(src.Pix[p], src.Pix[p+1], src.Pix[p+2]))
+		}
+	case *image.NRGBA:
+		at = func(x, y int) uint32 {
+			p := src.PixOffset(b.Min.X+x, b.Min.Y+y)
+			return uint32(RGBToPanopticID(src.Pix[p], src.Pix[p+1], src.Pix[p+2]))
+		}
+	default:
+		at = func(x, y int) uint32 {
+			cr, cg, cb, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			return uint32(RGBToPanopticID(uint8(cr>>8), uint8(cg>>8), uint8(cb>>8)))
+		}
+	}
+
+	size := [2]uint32{uint32(height), uint32(width)}
+	segs := make(map[int]MaskRLE)
+	for id, cols := range scanColumns(width, height, at) {
+		if id == 0 {
+			continue
+		}
+		segs[int(id)] = MaskRLE{Size: size, Counts: columnsToCounts(cols, size[0], size[1])}
+	}
+	return segs, nil
+}
+
+//DecodePanoptic decodes the PNG of a panoptic annotation and compares every
+//segment with the SegmentsInfo of ann. Segments missing on either side and
+//segments whose Area or Bbox differ from the pixels are reported as
+//mismatches ordered by segment id.
+func DecodePanoptic(r io.Reader, ann Annotation) (map[int]MaskRLE, []PanopticMismatch, error) {
+	segs, err := DecodePanopticPNG(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var mismatches []PanopticMismatch
+	listed := make(map[int]bool)
+	for _, info := range ann.SegmentsInfo {
+		listed[info.ID] = true
+		rle, ok := segs[info.ID]
+		if !ok {
+			mismatches = append(mismatches, PanopticMismatch{info.ID, "missing from png"})
+			continue
+		}
+		if area := int(rle.Area()); area != info.Area {
+			mismatches = append(mismatches, PanopticMismatch{info.ID, fmt.Sprintf("area %d, png has %d", info.Area, area)})
+		}
+		if bbox := rle.Bbox(); bbox != info.Bbox {
+			mismatches = append(mismatches, PanopticMismatch{info.ID, fmt.Sprintf("bbox %v, png has %v", info.Bbox, bbox)})
+		}
+	}
+	for id := range segs {
+		if !listed[id] {
+			mismatches = append(mismatches, PanopticMismatch{id, "not in segments_info"})
+		}
+	}
+	sort.SliceStable(mismatches, func(i, j int) bool { return mismatches[i].SegmentID < mismatches[j].SegmentID })
+	return segs, mismatches, nil
+}
+
+//EncodePanopticPNG paints every segment with the color of its id, all
+//segments must have the same non empty size. Overlapping segments are
+//painted in ascending id order.
+func EncodePanopticPNG(w io.Writer, segs map[int]MaskRLE) error {
+	ids := make([]int, 0, len(segs))
+	for id := range segs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var size [2]uint32
+	for i, id := range ids {
+		if i == 0 {
+			size = segs[id].Size
+		} else if segs[id].Size != size {
+			return errors.New("segments have different sizes")
+		}
+		if id <= 0 || id >= 256*256*256 {
+			return fmt.Errorf("segment id %d can not be stored in a png", id)
+		}
+	}
+	h, width := size[0], size[1]
+	// png.Encode rejects empty images with a less helpful error
+	if len(ids) == 0 {
+		return errors.New("no segments to encode")
+	}
+	if h == 0 || width == 0 {
+		return errors.New("segments have an empty size")
+	}
+	img := image.NewRGBA(image.Rect(0, 0, int(width), int(h)))
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	for _, id := range ids {
+		cr, cg, cb := PanopticIDToRGB(id)
+		for x, col := range countsToColumns(segs[id].Counts, h, width) {
+			for _, s := range col {
+				for y := int(s.start); y < int(s.end); y++ {
+					p := img.PixOffset(x, y)
+					img.Pix[p], img.Pix[p+1], img.Pix[p+2] = cr, cg, cb
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+//NewPSSegmentInfo returns the segment info of a segment with Area and Bbox
+//taken from its mask
+func NewPSSegmentInfo(id, categoryID int, rle MaskRLE, iscrowd byte) PSSegmentInfo {
+	return PSSegmentInfo{
+		ID:         id,
+		CategoryID: categoryID,
+		Area:       int(rle.Area()),
+		Bbox:       rle.Bbox(),
+		Iscrowd:    iscrowd,
+	}
+}
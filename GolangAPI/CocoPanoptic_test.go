@@ -0,0 +1,84 @@
+package coco
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_PanopticRoundTrip(t *testing.T) {
+	h, w := 12, 9
+	ids := make([]int, h*w)
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			switch {
+			case x < 3:
+				ids[x*h+y] = 0
+			case y < 5:
+				ids[x*h+y] = 7
+			case x < 6:
+				ids[x*h+y] = 70000
+			default:
+				ids[x*h+y] = 300
+			}
+		}
+	}
+	size := [2]uint32{uint32(h), uint32(w)}
+	segs := make(map[int]MaskRLE)
+	for _, id := range []int{7, 300, 70000} {
+		mask := make([]byte, h*w)
+		for i, v := range ids {
+			if v == id {
+				mask[i] = 1
+			}
+		}
+		var rle MaskRLE
+		rle.Encode(mask, size)
+		segs[id] = rle
+	}
+
+	var buf bytes.Buffer
+	if err := EncodePanopticPNG(&buf, segs); err != nil {
+		t.Fatal(err)
+	}
+	ann := Annotation{
+		ImageID:  1,
+		FileName: "000000000001.png",
+		SegmentsInfo: []PSSegmentInfo{
+			NewPSSegmentInfo(7, 1, segs[7], 0),
+			NewPSSegmentInfo(300, 2, segs[300], 0),
+			NewPSSegmentInfo(12, 3, segs[300], 0),
+		},
+	}
+	ann.SegmentsInfo[1].Area++
+	decoded, mismatches, err := DecodePanoptic(bytes.NewReader(buf.Bytes()), ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, rle := range segs {
+		if decoded[id].String() != rle.String() {
+			t.Fatalf("segment %d differs after round trip", id)
+		}
+	}
+	if len(decoded) != len(segs) {
+		t.Fatalf("decoded %d segments, want %d", len(decoded), len(segs))
+	}
+	want := []int{12, 300, 70000}
+	if len(mismatches) != len(want) {
+		t.Fatalf("unexpected mismatches: %v", mismatches)
+	}
+	for i := range want {
+		if mismatches[i].SegmentID != want[i] {
+			t.Fatalf("unexpected mismatches: %v", mismatches)
+		}
+	}
+}
+
+func Test_EncodePanopticPNGEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodePanopticPNG(&buf, map[int]MaskRLE{}); err == nil {
+		t.Fatal("expected an error without segments")
+	}
+	if err := EncodePanopticPNG(&buf, map[int]MaskRLE{1: {Size: [2]uint32{0, 4}}}); err == nil {
+		t.Fatal("expected an error for an empty size")
+	}
+}
@@ -0,0 +1,457 @@
+package coco
+
+import (
+	"math"
+	"sort"
+)
+
+// Exact geometry of polygon segmentations without rasterization.
+// A SegmentationPolygon is the union of its rings like in rleFrPoly. Rings
+// that cross themselves are repaired by splitting them at every crossing
+// into simple loops, all measures are taken on the union of the repaired
+// loops. For simple rings this is the area rleFrPoly rasterizes, a ring
+// that winds around a region twice or an even number of times covers that
+// region here while the even-odd fill of rleFrPoly leaves it out.
+//  Area/Bbox/Centroid - Shoelace measures of the polygon.
+//  Contains           - Point in polygon test.
+//  SelfIntersections  - Points where a ring crosses or touches itself.
+//  Repair/Normalize   - Simple rings with a positive shoelace area.
+//  IntersectionArea/UnionArea/IoU - Overlap of two polygons by edge clipping.
+
+// polyEps is the distance below which points count as lying on an edge.
+const polyEps = 1e-9
+
+type point struct {
+	x, y float64
+}
+
+func (p point) sub(q point) point {
+	return point{p.x - q.x, p.y - q.y}
+}
+
+func (p point) lerp(q point, t float64) point {
+	return point{p.x + t*(q.x-p.x), p.y + t*(q.y-p.y)}
+}
+
+func cross(a, b point) float64 {
+	return a.x*b.y - a.y*b.x
+}
+
+func dot(a, b point) float64 {
+	return a.x*b.x + a.y*b.y
+}
+
+// rings converts the flat rings into points, repeated and closing vertices
+// are dropped and rings with less than 3 points are skipped.
+func (s SegmentationPolygon) rings() [][]point {
+	var rings [][]point
+	for _, flat := range s {
+		var ring []point
+		for k := 0; k+1 < len(flat); k += 2 {
+			p := point{float64(flat[k]), float64(flat[k+1])}
+			if len(ring) > 0 && ring[len(ring)-1] == p {
+				continue
+			}
+			ring = append(ring, p)
+		}
+		for len(ring) > 1 && ring[len(ring)-1] == ring[0] {
+			ring = ring[:len(ring)-1]
+		}
+		if len(ring) >= 3 {
+			rings = append(rings, ring)
+		}
+	}
+	return rings
+}
+
+func signedArea(ring []point) float64 {
+	a := 0.0
+	for i := range ring {
+		a += cross(ring[i], ring[(i+1)%len(ring)])
+	}
+	return a / 2
+}
+
+// onSegment reports whether m lies on the segment a-b and where, m = a+t(b-a).
+func onSegment(m, a, b point) (t float64, ok bool) {
+	d := b.sub(a)
+	l2 := dot(d, d)
+	if l2 == 0 {
+		return 0, m == a
+	}
+	l := math.Sqrt(l2)
+	if math.Abs(cross(d, m.sub(a)))/l > polyEps {
+		return 0, false
+	}
+	t = dot(d, m.sub(a)) / l2
+	return t, t*l >= -polyEps && (t-1)*l <= polyEps
+}
+
+// crossing returns where a-b and c-d cross in the interior of both segments.
+func crossing(a, b, c, d point) (t float64, x point, ok bool) {
+	r, s := b.sub(a), d.sub(c)
+	den := cross(r, s)
+	if den == 0 {
+		return 0, point{}, false
+	}
+	ca := c.sub(a)
+	t = cross(ca, s) / den
+	u := cross(ca, r) / den
+	if t <= 0 || t >= 1 || u <= 0 || u >= 1 {
+		return 0, point{}, false
+	}
+	return t, a.lerp(b, t), true
+}
+
+type edgePoint struct {
+	t float64
+	p point
+}
+
+// splitRing inserts every point where the ring crosses or touches itself as
+// a vertex. A crossing is computed once and inserted into both edges, so
+// the vertex is bit for bit the same in both places.
+func splitRing(ring []point) (out []point, touches []point) {
+	n := len(ring)
+	inserts := make([][]edgePoint, n)
+	for i := 0; i < n; i++ {
+		a, b := ring[i], ring[(i+1)%n]
+		for k := i + 1; k < n; k++ {
+			c, d := ring[k], ring[(k+1)%n]
+			adjacent := k == i+1 || (i == 0 && k == n-1)
+			touching := false
+			for _, q := range [2]point{c, d} {
+				if q == a || q == b {
+					if !adjacent {
+						touches = append(touches, q)
+					}
+					touching = true
+					continue
+				}
+				if t, ok := onSegment(q, a, b); ok {
+					inserts[i] = append(inserts[i], edgePoint{t, q})
+					touching = true
+				}
+			}
+			for _, q := range [2]point{a, b} {
+				if q == c || q == d {
+					continue
+				}
+				if t, ok := onSegment(q, c, d); ok {
+					inserts[k] = append(inserts[k], edgePoint{t, q})
+					touching = true
+				}
+			}
+			if touching {
+				continue
+			}
+			if t, x, ok := crossing(a, b, c, d); ok {
+				u, _ := onSegment(x, c, d)
+				inserts[i] = append(inserts[i], edgePoint{t, x})
+				inserts[k] = append(inserts[k], edgePoint{u, x})
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		out = append(out, ring[i])
+		ins := inserts[i]
+		sort.Slice(ins, func(a, b int) bool { return ins[a].t < ins[b].t })
+		for _, e := range ins {
+			if out[len(out)-1] != e.p && e.p != ring[(i+1)%n] {
+				out = append(out, e.p)
+				touches = append(touches, e.p)
+			}
+		}
+	}
+	return out, touches
+}
+
+// splitLoops cuts a closed path at every repeated vertex into loops that
+// visit each vertex once.
+func splitLoops(path []point) [][]point {
+	var loops [][]point
+	var stack []point
+	index := make(map[point]int)
+	for _, p := range path {
+		if k, ok := index[p]; ok {
+			loops = append(loops, append([]point(nil), stack[k:]...))
+			for _, q := range stack[k+1:] {
+				delete(index, q)
+			}
+			stack = stack[:k+1]
+			continue
+		}
+		index[p] = len(stack)
+		stack = append(stack, p)
+	}
+	return append(loops, stack)
+}
+
+// loops returns the simple loops of the repaired polygon, every loop has a
+// positive shoelace area.
+func (s SegmentationPolygon) loops() [][]point {
+	var loops [][]point
+	for _, ring := range s.rings() {
+		path, _ := splitRing(ring)
+		for _, loop := range splitLoops(path) {
+			if len(loop) < 3 {
+				continue
+			}
+			a := signedArea(loop)
+			if math.Abs(a) <= polyEps {
+				continue
+			}
+			if a < 0 {
+				reversePoints(loop)
+			}
+			loops = append(loops, loop)
+		}
+	}
+	return loops
+}
+
+func reversePoints(ring []point) {
+	for a, b := 0, len(ring)-1; a < b; a, b = a+1, b-1 {
+		ring[a], ring[b] = ring[b], ring[a]
+	}
+}
+
+func loopsToPolygon(loops [][]point) SegmentationPolygon {
+	out := make(SegmentationPolygon, len(loops))
+	for i, loop := range loops {
+		flat := make([]float32, 0, 2*len(loop))
+		for _, p := range loop {
+			flat = append(flat, float32(p.x), float32(p.y))
+		}
+		out[i] = flat
+	}
+	return out
+}
+
+// insideLoop is a crossing number test, points on the boundary are undefined.
+func insideLoop(m point, loop []point) bool {
+	in := false
+	for i := range loop {
+		a, b := loop[i], loop[(i+1)%len(loop)]
+		if (a.y > m.y) != (b.y > m.y) {
+			x := a.x + (m.y-a.y)*(b.x-a.x)/(b.y-a.y)
+			if m.x < x {
+				in = !in
+			}
+		}
+	}
+	return in
+}
+
+// onLoop reports whether m lies on an edge of loop and whether that edge
+// runs in the direction of dir.
+func onLoop(m, dir point, loop []point) (on, same bool) {
+	for i := range loop {
+		a, b := loop[i], loop[(i+1)%len(loop)]
+		if _, ok := onSegment(m, a, b); ok {
+			return true, dot(dir, b.sub(a)) > 0
+		}
+	}
+	return false, false
+}
+
+// unionMoments integrates over the boundary of the union of simple positive
+// loops. Every edge is clipped against the other loops and only the pieces
+// outside of them are kept, a piece shared by two loops is kept once when
+// both run the same way and dropped when they run opposite ways.
+func unionMoments(loops [][]point) (area, mx, my float64) {
+	for i, li := range loops {
+		for e := range li {
+			a, b := li[e], li[(e+1)%len(li)]
+			ts := []float64{0, 1}
+			for j, lj := range loops {
+				if j == i {
+					continue
+				}
+				for f := range lj {
+					c, d := lj[f], lj[(f+1)%len(lj)]
+					for _, q := range [2]point{c, d} {
+						if t, ok := onSegment(q, a, b); ok {
+							ts = append(ts, t)
+						}
+					}
+					if t, _, ok := crossing(a, b, c, d); ok {
+						ts = append(ts, t)
+					}
+				}
+			}
+			sort.Float64s(ts)
+			dir := b.sub(a)
+			for k := 0; k+1 < len(ts); k++ {
+				t0, t1 := math.Max(ts[k], 0), math.Min(ts[k+1], 1)
+				if t1 <= t0 {
+					continue
+				}
+				if !boundaryKept(i, a.lerp(b, (t0+t1)/2), dir, loops) {
+					continue
+				}
+				p, q := a.lerp(b, t0), a.lerp(b, t1)
+				c := cross(p, q)
+				area += c
+				mx += (p.x + q.x) * c
+				my += (p.y + q.y) * c
+			}
+		}
+	}
+	return area / 2, mx / 6, my / 6
+}
+
+func boundaryKept(i int, m, dir point, loops [][]point) bool {
+	for j, lj := range loops {
+		if j == i {
+			continue
+		}
+		if on, same := onLoop(m, dir, lj); on {
+			if !same || j < i {
+				return false
+			}
+			continue
+		}
+		if insideLoop(m, lj) {
+			return false
+		}
+	}
+	return true
+}
+
+//Area Compute the exact area of the polygon, overlapping rings count once
+func (s SegmentationPolygon) Area() float64 {
+	area, _, _ := unionMoments(s.loops())
+	return area
+}
+
+//Bbox Get bounding box [x, y, w, h] surrounding the vertices of the polygon
+func (s SegmentationPolygon) Bbox() [4]float32 {
+	first := true
+	var x0, y0, x1, y1 float32
+	for _, flat := range s {
+		for k := 0; k+1 < len(flat); k += 2 {
+			x, y := flat[k], flat[k+1]
+			if first {
+				x0, y0, x1, y1 = x, y, x, y
+				first = false
+			}
+			x0, y0 = min32(x0, x), min32(y0, y)
+			x1, y1 = max32(x1, x), max32(y1, y)
+		}
+	}
+	return [4]float32{x0, y0, x1 - x0, y1 - y0}
+}
+
+//Centroid Compute the center of mass of the polygon, a polygon without area
+//gives the mean of its vertices
+func (s SegmentationPolygon) Centroid() (x, y float64) {
+	area, mx, my := unionMoments(s.loops())
+	if area > polyEps {
+		return mx / area, my / area
+	}
+	n := 0
+	for _, flat := range s {
+		for k := 0; k+1 < len(flat); k += 2 {
+			x += float64(flat[k])
+			y += float64(flat[k+1])
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return x / float64(n), y / float64(n)
+}
+
+//Contains reports whether (x, y) lies inside the polygon or on its boundary
+func (s SegmentationPolygon) Contains(x, y float64) bool {
+	m := point{x, y}
+	for _, loop := range s.loops() {
+		if on, _ := onLoop(m, point{}, loop); on || insideLoop(m, loop) {
+			return true
+		}
+	}
+	return false
+}
+
+//SelfIntersections returns the points where a ring crosses or touches
+//itself, every point is reported once per pair of edges. Rings of one
+//polygon may overlap each other, that is not a self intersection.
+func (s SegmentationPolygon) SelfIntersections() [][2]float64 {
+	var out [][2]float64
+	seen := make(map[point]bool)
+	for _, ring := range s.rings() {
+		_, touches := splitRing(ring)
+		for _, p := range touches {
+			if !seen[p] {
+				seen[p] = true
+				out = append(out, [2]float64{p.x, p.y})
+			}
+		}
+	}
+	return out
+}
+
+//Repair splits every ring that crosses or touches itself into simple rings
+//and drops rings without area. All rings of the result have a positive
+//shoelace area and cover the same points as the original polygon.
+func (s SegmentationPolygon) Repair() SegmentationPolygon {
+	return loopsToPolygon(s.loops())
+}
+
+//Normalize orients every ring to a positive shoelace area, which is
+//clockwise on screen as the image y axis points down. Unlike Repair the
+//rings are not split.
+func (s SegmentationPolygon) Normalize() SegmentationPolygon {
+	out := make(SegmentationPolygon, len(s))
+	for i, flat := range s {
+		ring := append([]float32(nil), flat...)
+		pts := SegmentationPolygon{ring}.rings()
+		if len(pts) == 1 && signedArea(pts[0]) < 0 {
+			for a, b := 0, len(ring)/2-1; a < b; a, b = a+1, b-1 {
+				ring[2*a], ring[2*b] = ring[2*b], ring[2*a]
+				ring[2*a+1], ring[2*b+1] = ring[2*b+1], ring[2*a+1]
+			}
+		}
+		out[i] = ring
+	}
+	return out
+}
+
+//UnionArea Compute the exact area covered by s or o
+func (s SegmentationPolygon) UnionArea(o SegmentationPolygon) float64 {
+	area, _, _ := unionMoments(append(s.loops(), o.loops()...))
+	return area
+}
+
+//IntersectionArea Compute the exact area covered by both s and o
+func (s SegmentationPolygon) IntersectionArea(o SegmentationPolygon) float64 {
+	i, _, _ := polygonOverlap(s, o)
+	return i
+}
+
+func polygonOverlap(s, o SegmentationPolygon) (i, u, a float64) {
+	ls, lo := s.loops(), o.loops()
+	a, _, _ = unionMoments(ls)
+	b, _, _ := unionMoments(lo)
+	u, _, _ = unionMoments(append(ls, lo...))
+	i = a + b - u
+	if i < 0 {
+		i = 0
+	}
+	return i, u, a
+}
+
+//IoU Compute the exact intersection over union between s as detection and
+//gt, for a crowd gt the union is the area of s like MaskRLE.IoU
+func (s SegmentationPolygon) IoU(gt SegmentationPolygon, iscrowd bool) float64 {
+	i, u, a := polygonOverlap(s, gt)
+	if i <= 0 {
+		return 0
+	}
+	if iscrowd {
+		u = a
+	}
+	return i / u
+}
@@ -0,0 +1,209 @@
+package coco
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func rect(x, y, w, h float32) []float32 {
+	return []float32{x, y, x + w, y, x + w, y + h, x, y + h}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-6*(1+math.Abs(b))
+}
+
+func Test_PolygonMeasures(t *testing.T) {
+	sq := SegmentationPolygon{rect(1, 2, 4, 3)}
+	if a := sq.Area(); !near(a, 12) {
+		t.Fatalf("area %v", a)
+	}
+	if bb := sq.Bbox(); bb != [4]float32{1, 2, 4, 3} {
+		t.Fatalf("bbox %v", bb)
+	}
+	if x, y := sq.Centroid(); !near(x, 3) || !near(y, 3.5) {
+		t.Fatalf("centroid %v %v", x, y)
+	}
+	if !sq.Contains(3, 3) || !sq.Contains(1, 2) || sq.Contains(0, 0) {
+		t.Fatal("contains")
+	}
+
+	// the reversed ring has the same area and is normalized back
+	rev := SegmentationPolygon{{1, 2, 1, 5, 5, 5, 5, 2}}
+	if a := rev.Area(); !near(a, 12) {
+		t.Fatalf("reversed area %v", a)
+	}
+	if a := signedArea(rev.Normalize().rings()[0]); !near(a, 12) {
+		t.Fatalf("normalized signed area %v", a)
+	}
+
+	// overlapping rings of one polygon count once
+	two := SegmentationPolygon{rect(0, 0, 2, 2), rect(1, 1, 2, 2)}
+	if a := two.Area(); !near(a, 7) {
+		t.Fatalf("overlapping rings area %v", a)
+	}
+	if x, y := two.Centroid(); !near(x, 1.5) || !near(y, 1.5) {
+		t.Fatalf("overlapping rings centroid %v %v", x, y)
+	}
+}
+
+func Test_PolygonSelfIntersection(t *testing.T) {
+	// a bow tie crossing at (1, 1), both lobes have area 1
+	bow := SegmentationPolygon{{0, 0, 2, 2, 2, 0, 0, 2}}
+	pts := bow.SelfIntersections()
+	if len(pts) != 1 || !near(pts[0][0], 1) || !near(pts[0][1], 1) {
+		t.Fatalf("self intersections %v", pts)
+	}
+	rep := bow.Repair()
+	if len(rep) != 2 {
+		t.Fatalf("repair gave %d rings", len(rep))
+	}
+	for _, ring := range rep {
+		r := SegmentationPolygon{ring}
+		if len(r.SelfIntersections()) != 0 || signedArea(r.rings()[0]) <= 0 {
+			t.Fatalf("repaired ring %v is not simple and positive", ring)
+		}
+	}
+	if a := bow.Area(); !near(a, 2) {
+		t.Fatalf("bow tie area %v", a)
+	}
+	if len(SegmentationPolygon{rect(0, 0, 3, 3)}.SelfIntersections()) != 0 {
+		t.Fatal("rectangle is simple")
+	}
+
+	// a ring touching itself in a vertex
+	touch := SegmentationPolygon{{0, 0, 2, 0, 2, 2, 4, 2, 4, 4, 2, 4, 2, 2, 0, 2}}
+	if pts := touch.SelfIntersections(); len(pts) != 1 || pts[0] != [2]float64{2, 2} {
+		t.Fatalf("touching vertex %v", pts)
+	}
+	if a := touch.Area(); !near(a, 8) {
+		t.Fatalf("touching area %v", a)
+	}
+
+	// a pentagram, the repaired star covers its center
+	star := make([]float32, 0, 10)
+	for k := 0; k < 5; k++ {
+		a := math.Pi/2 + float64(2*k)*2*math.Pi/5
+		star = append(star, float32(10*math.Cos(a)), float32(10*math.Sin(a)))
+	}
+	sp := SegmentationPolygon{star}
+	if n := len(sp.SelfIntersections()); n != 5 {
+		t.Fatalf("pentagram has %d self intersections", n)
+	}
+	if !sp.Contains(0, 0) {
+		t.Fatal("pentagram center")
+	}
+	if a, b := sp.Area(), sp.Repair().Area(); !near(a, b) || a <= 0 {
+		t.Fatalf("pentagram area %v, repaired %v", a, b)
+	}
+}
+
+func Test_PolygonOverlap(t *testing.T) {
+	a := SegmentationPolygon{rect(0, 0, 4, 4)}
+	b := SegmentationPolygon{rect(2, 2, 4, 4)}
+	if i := a.IntersectionArea(b); !near(i, 4) {
+		t.Fatalf("intersection %v", i)
+	}
+	if u := a.UnionArea(b); !near(u, 28) {
+		t.Fatalf("union %v", u)
+	}
+	if iou := a.IoU(b, false); !near(iou, 4.0/28) {
+		t.Fatalf("iou %v", iou)
+	}
+	if iou := a.IoU(b, true); !near(iou, 4.0/16) {
+		t.Fatalf("crowd iou %v", iou)
+	}
+	if iou := a.IoU(a, false); !near(iou, 1) {
+		t.Fatalf("self iou %v", iou)
+	}
+	// neighbours share an edge but no area
+	c := SegmentationPolygon{rect(4, 0, 4, 4)}
+	if i, u := a.IntersectionArea(c), a.UnionArea(c); !near(i, 0) || !near(u, 32) {
+		t.Fatalf("neighbours %v %v", i, u)
+	}
+	// nested
+	d := SegmentationPolygon{rect(1, 1, 1, 1)}
+	if i := a.IntersectionArea(d); !near(i, 1) {
+		t.Fatalf("nested %v", i)
+	}
+	if iou := a.IoU(SegmentationPolygon{rect(10, 10, 1, 1)}, false); iou != 0 {
+		t.Fatalf("disjoint %v", iou)
+	}
+}
+
+// clipConvex clips the positive convex polygon p with the convex polygon q.
+func clipConvex(p, q []point) []point {
+	out := p
+	for i := range q {
+		a, b := q[i], q[(i+1)%len(q)]
+		in := out
+		out = nil
+		for k := range in {
+			c, d := in[k], in[(k+1)%len(in)]
+			ci, di := cross(b.sub(a), c.sub(a)) >= 0, cross(b.sub(a), d.sub(a)) >= 0
+			if ci {
+				out = append(out, c)
+			}
+			if ci != di {
+				r, s := d.sub(c), b.sub(a)
+				t := cross(a.sub(c), s) / cross(r, s)
+				out = append(out, c.lerp(d, t))
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+	}
+	return out
+}
+
+func randomConvex(rnd *rand.Rand) SegmentationPolygon {
+	cx, cy, r := rnd.Float64()*20, rnd.Float64()*20, 2+rnd.Float64()*8
+	n := 3 + rnd.Intn(6)
+	ring := make([]float32, 0, 2*n)
+	for k := 0; k < n; k++ {
+		a := (float64(k) + 0.8*rnd.Float64()) * 2 * math.Pi / float64(n)
+		ring = append(ring, float32(cx+r*math.Cos(a)), float32(cy+r*math.Sin(a)))
+	}
+	return SegmentationPolygon{ring}
+}
+
+func Test_PolygonConvexClipping(t *testing.T) {
+	rnd := rand.New(rand.NewSource(5))
+	for it := 0; it < 500; it++ {
+		a, b := randomConvex(rnd), randomConvex(rnd)
+		clip := clipConvex(a.rings()[0], b.rings()[0])
+		want := 0.0
+		if len(clip) >= 3 {
+			want = signedArea(clip)
+		}
+		if got := a.IntersectionArea(b); math.Abs(got-want) > 1e-6*(1+want) {
+			t.Fatalf("case %d: intersection %v, clipping %v", it, got, want)
+		}
+		if got, want := a.UnionArea(b), a.Area()+b.Area()-want; !near(got, want) {
+			t.Fatalf("case %d: union %v, expected %v", it, got, want)
+		}
+	}
+}
+
+func Test_PolygonMatchesRaster(t *testing.T) {
+	rnd := rand.New(rand.NewSource(9))
+	h, w := uint32(120), uint32(120)
+	for it := 0; it < 20; it++ {
+		poly := randomConvex(rnd)
+		for k := range poly[0] {
+			poly[0][k] = poly[0][k]*2.5 + 35
+		}
+		flat := make([]float64, len(poly[0]))
+		for k, v := range poly[0] {
+			flat[k] = float64(v)
+		}
+		area := float64(RLEFromPoly(&flat[0], uint32(len(flat)/2), h, w).MaskRLE(0).Area())
+		// rasterization errs by about half a pixel along the boundary
+		bb := poly.Bbox()
+		if got := poly.Area(); math.Abs(got-area) > float64(bb[2]+bb[3]) {
+			t.Fatalf("case %d: exact area %v, raster %v", it, got, area)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package coco
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Post-processing of detection results ([]Annotation with Score set).
+// Results are grouped per image and category (or per image only when
+// ClassAgnostic is set), suppressed inside every group and finally capped to
+// the top-k results per category and per image.
+//  NMSHard         - Greedy non-maximum suppression.
+//  NMSSoftLinear   - Soft-NMS, scores decay by (1 - iou) above the threshold.
+//  NMSSoftGaussian - Soft-NMS, scores decay by exp(-iou^2 / sigma).
+//  NMSMatrix       - Matrix-NMS as in SOLOv2, all decays computed in parallel,
+//                    gaussian decay exp(-sigma * (iou^2 - compensate^2)).
+
+//NMSMethod selects the suppression algorithm of PostProcess
+type NMSMethod int
+
+const (
+	NMSHard NMSMethod = iota
+	NMSSoftLinear
+	NMSSoftGaussian
+	NMSMatrix
+)
+
+//IoUType selects how the overlap of two results is measured
+type IoUType string
+
+const (
+	IoUBbox IoUType = "bbox"
+	IoUSegm IoUType = "segm"
+)
+
+//PostProcessOptions controls PostProcess
+type PostProcessOptions struct {
+	Method  NMSMethod
+	IoUType IoUType
+	// results overlapping more than IoUThreshold are suppressed (hard NMS)
+	// or decayed (linear Soft-NMS)
+	IoUThreshold float64
+	// gaussian parameter, following the papers it has opposite meanings:
+	// NMSSoftGaussian divides by it, exp(-iou^2 / Sigma), so a larger Sigma
+	// suppresses less (defaults to 0.5). NMSMatrix multiplies by it,
+	// exp(-Sigma * (iou^2 - compensate^2)), so a larger Sigma suppresses
+	// more, and it uses the linear kernel when Sigma is 0.
+	Sigma float64
+	// results whose score falls below ScoreThreshold are dropped
+	ScoreThreshold float32
+	// suppress across categories instead of per category
+	ClassAgnostic bool
+	// keep at most this many results per image and category / per image, 0 keeps all
+	TopKPerCategory int
+	TopKPerImage    int
+}
+
+type nmsGroup struct {
+	imgId, catId int
+}
+
+// boxIoU computes the iou of two [x, y, w, h] boxes like bbIou without crowd.
+func boxIoU(d, g [4]float32) float64 {
+	if !bbOverlap(d, g) {
+		return 0
+	}
+	w := float64(min32(d[0]+d[2], g[0]+g[2]) - max32(d[0], g[0]))
+	h := float64(min32(d[1]+d[3], g[1]+g[3]) - max32(d[1], g[1]))
+	i := w * h
+	return i / (float64(d[2])*float64(d[3]) + float64(g[2])*float64(g[3]) - i)
+}
+
+// iouMatrix computes the pairwise iou of the results, row-major.
+func iouMatrix(results []Annotation, iouType IoUType) ([]float64, error) {
+	n := len(results)
+	out := make([]float64, n*n)
+	switch iouType {
+	case IoUSegm:
+		rles := make([]MaskRLE, n)
+		for i := range results {
+			rle, err := NewMaskRLE(results[i].Segmentation.SegmentationHelper)
+			if err != nil {
+				return nil, err
+			}
+			rles[i] = rle
+		}
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				if !bbOverlap(rles[i].Bbox(), rles[j].Bbox()) {
+					continue
+				}
+				out[i*n+j] = rles[i].IoU(rles[j], false)
+				out[j*n+i] = out[i*n+j]
+			}
+		}
+	case IoUBbox, "":
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				out[i*n+j] = boxIoU(results[i].Bbox, results[j].Bbox)
+				out[j*n+i] = out[i*n+j]
+			}
+		}
+	default:
+		return nil, errors.New("unknown iou type " + string(iouType))
+	}
+	return out, nil
+}
+
+func sortByScore(results []Annotation) {
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+}
+
+// suppress runs the configured NMS on one group and returns the kept results
+// with their (possibly decayed) scores.
+func suppress(results []Annotation, opts PostProcessOptions) ([]Annotation, error) {
+	sortByScore(results)
+	n := len(results)
+	iou, err := iouMatrix(results, opts.IoUType)
+	if err != nil {
+		return nil, err
+	}
+	var kept []Annotation
+	switch opts.Method {
+	case NMSHard:
+		keep := make([]bool, n)
+		for i := range keep {
+			keep[i] = true
+		}
+		for i := 0; i < n; i++ {
+			if !keep[i] {
+				continue
+			}
+			for j := i + 1; j < n; j++ {
+				if keep[j] && iou[i*n+j] > opts.IoUThreshold {
+					keep[j] = false
+				}
+			}
+		}
+		for i := range results {
+			if keep[i] {
+				kept = append(kept, results[i])
+			}
+		}
+
+	case NMSSoftLinear, NMSSoftGaussian:
+		scores := make([]float64, n)
+		for i := range results {
+			scores[i] = float64(results[i].Score)
+		}
+		sigma := opts.Sigma
+		if sigma <= 0 {
+			sigma = 0.5
+		}
+		done := make([]bool, n)
+		for {
+			best := -1
+			for i := 0; i < n; i++ {
+				if !done[i] && (best < 0 || scores[i] > scores[best]) {
+					best = i
+				}
+			}
+			if best < 0 || scores[best] < float64(opts.ScoreThreshold) {
+				break
+			}
+			done[best] = true
+			r := results[best]
+			r.Score = float32(scores[best])
+			kept = append(kept, r)
+			for j := 0; j < n; j++ {
+				if done[j] {
+					continue
+				}
+				o := iou[best*n+j]
+				if opts.Method == NMSSoftGaussian {
+					scores[j] *= math.Exp(-o * o / sigma)
+				} else if o > opts.IoUThreshold {
+					scores[j] *= 1 - o
+				}
+			}
+		}
+
+	case NMSMatrix:
+		// compensate[i] is the largest overlap of i with a higher scored result
+		compensate := make([]float64, n)
+		for i := 0; i < n; i++ {
+			for k := 0; k < i; k++ {
+				if iou[k*n+i] > compensate[i] {
+					compensate[i] = iou[k*n+i]
+				}
+			}
+		}
+		for j := 0; j < n; j++ {
+			decay := 1.0
+			for i := 0; i < j; i++ {
+				var d float64
+				if opts.Sigma > 0 {
+					d = math.Exp(-opts.Sigma * (iou[i*n+j]*iou[i*n+j] - compensate[i]*compensate[i]))
+				} else if compensate[i] >= 1 {
+					// i duplicates a higher scored result which already
+					// decays j, dividing by zero would give NaN or +Inf
+					continue
+				} else {
+					d = (1 - iou[i*n+j]) / (1 - compensate[i])
+				}
+				if d < decay {
+					decay = d
+				}
+			}
+			r := results[j]
+			r.Score = float32(float64(r.Score) * decay)
+			kept = append(kept, r)
+		}
+		sortByScore(kept)
+
+	default:
+		return nil, errors.New("unknown nms method")
+	}
+
+	out := kept[:0]
+	for _, r := range kept {
+		if r.Score >= opts.ScoreThreshold {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+//PostProcess applies non-maximum suppression and top-k capping to detection
+//results. The output is ordered by image id and descending score.
+func PostProcess(results []Annotation, opts PostProcessOptions) ([]Annotation, error) {
+	groups := make(map[nmsGroup][]Annotation)
+	var keys []nmsGroup
+	for _, r := range results {
+		key := nmsGroup{imgId: r.ImageID, catId: r.CategoryID}
+		if opts.ClassAgnostic {
+			key.catId = 0
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], r)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].imgId != keys[j].imgId {
+			return keys[i].imgId < keys[j].imgId
+		}
+		return keys[i].catId < keys[j].catId
+	})
+
+	perImage := make(map[int][]Annotation)
+	var imgIds []int
+	for _, key := range keys {
+		kept, err := suppress(groups[key], opts)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := perImage[key.imgId]; !ok {
+			imgIds = append(imgIds, key.imgId)
+		}
+		perImage[key.imgId] = append(perImage[key.imgId], kept...)
+	}
+
+	var out []Annotation
+	for _, imgId := range imgIds {
+		list := perImage[imgId]
+		sortByScore(list)
+		if opts.TopKPerCategory > 0 {
+			count := make(map[int]int)
+			capped := list[:0]
+			for _, r := range list {
+				if count[r.CategoryID] < opts.TopKPerCategory {
+					count[r.CategoryID]++
+					capped = append(capped, r)
+				}
+			}
+			list = capped
+		}
+		if opts.TopKPerImage > 0 && len(list) > opts.TopKPerImage {
+			list = list[:opts.TopKPerImage]
+		}
+		out = append(out, list...)
+	}
+	return out, nil
+}
@@ -0,0 +1,152 @@
+package coco
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func randomResults(rnd *rand.Rand, n int) []Annotation {
+	results := make([]Annotation, n)
+	for i := range results {
+		x, y := rnd.Float32()*50, rnd.Float32()*50
+		results[i] = Annotation{
+			ImageID:    1 + rnd.Intn(2),
+			CategoryID: 1 + rnd.Intn(3),
+			Bbox:       [4]float32{x, y, 10 + rnd.Float32()*20, 10 + rnd.Float32()*20},
+			Score:      rnd.Float32(),
+		}
+	}
+	return results
+}
+
+func Test_NonMaxSupBB(t *testing.T) {
+	rnd := rand.New(rand.NewSource(21))
+	results := randomResults(rnd, 40)
+	for i := range results {
+		results[i].ImageID, results[i].CategoryID = 1, 1
+	}
+	sortByScore(results)
+	var bb BB
+	for _, r := range results {
+		bb = append(bb, float64(r.Bbox[0]), float64(r.Bbox[1]), float64(r.Bbox[2]), float64(r.Bbox[3]))
+	}
+	keep := NonMaxSupBB(bb, 0.3)
+	kept, err := PostProcess(results, PostProcessOptions{Method: NMSHard, IoUThreshold: 0.3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for i, k := range keep {
+		if !k {
+			continue
+		}
+		if n >= len(kept) || kept[n].Bbox != results[i].Bbox {
+			t.Fatalf("PostProcess and NonMaxSupBB disagree at %d", i)
+		}
+		n++
+	}
+	if n != len(kept) || n == len(results) {
+		t.Fatalf("kept %d of %d boxes, NonMaxSupBB kept %d", len(kept), len(results), n)
+	}
+}
+
+func Test_PostProcess(t *testing.T) {
+	rnd := rand.New(rand.NewSource(22))
+	results := randomResults(rnd, 200)
+	for _, method := range []NMSMethod{NMSHard, NMSSoftLinear, NMSSoftGaussian, NMSMatrix} {
+		out, err := PostProcess(append([]Annotation(nil), results...), PostProcessOptions{
+			Method:          method,
+			IoUThreshold:    0.5,
+			Sigma:           2,
+			ScoreThreshold:  0.05,
+			TopKPerCategory: 10,
+			TopKPerImage:    25,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		perImage := make(map[int]int)
+		perCat := make(map[[2]int]int)
+		for i, r := range out {
+			perImage[r.ImageID]++
+			perCat[[2]int{r.ImageID, r.CategoryID}]++
+			if r.Score < 0.05 {
+				t.Fatalf("method %d: score %v below threshold", method, r.Score)
+			}
+			if i > 0 && out[i-1].ImageID == r.ImageID && out[i-1].Score < r.Score {
+				t.Fatalf("method %d: results are not sorted by score", method)
+			}
+		}
+		for img, c := range perImage {
+			if c > 25 {
+				t.Fatalf("method %d: image %d has %d results", method, img, c)
+			}
+		}
+		for key, c := range perCat {
+			if c > 10 {
+				t.Fatalf("method %d: image/category %v has %d results", method, key, c)
+			}
+		}
+	}
+}
+
+func Test_MatrixNMSDuplicates(t *testing.T) {
+	box := [4]float32{10, 10, 20, 20}
+	results := []Annotation{
+		{ImageID: 1, CategoryID: 1, Score: 0.9, Bbox: box},
+		{ImageID: 1, CategoryID: 1, Score: 0.8, Bbox: box},
+		{ImageID: 1, CategoryID: 1, Score: 0.7, Bbox: box},
+		{ImageID: 1, CategoryID: 1, Score: 0.6, Bbox: [4]float32{50, 50, 10, 10}},
+		{ImageID: 1, CategoryID: 1, Score: 0.5, Bbox: [4]float32{15, 10, 20, 20}},
+	}
+	out, err := PostProcess(results, PostProcessOptions{Method: NMSMatrix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 5 || out[0].Score != 0.9 || out[1].Score != 0.6 {
+		t.Fatalf("matrix NMS changed the best or the separate box: %+v", out)
+	}
+	// the partly overlapping box has iou 0.6 with the best one
+	if d := out[2].Score - 0.5*0.4; d > 1e-6 || d < -1e-6 {
+		t.Fatalf("partly overlapping box has score %v", out[2].Score)
+	}
+	for _, r := range out[3:] {
+		if r.Score != 0 {
+			t.Fatalf("duplicate box has score %v, want 0", r.Score)
+		}
+	}
+}
+
+func Test_PostProcessSegm(t *testing.T) {
+	h, w := 20, 20
+	box := func(x0, y0, x1, y1 int) *SegmentationRLE {
+		mask := make([]byte, h*w)
+		for x := x0; x < x1; x++ {
+			for y := y0; y < y1; y++ {
+				mask[x*h+y] = 1
+			}
+		}
+		return EncodeMaskToSegment(mask, [2]uint32{uint32(h), uint32(w)})
+	}
+	results := []Annotation{
+		{ImageID: 1, CategoryID: 1, Score: 0.9, Segmentation: Segment{box(0, 0, 10, 10)}},
+		{ImageID: 1, CategoryID: 1, Score: 0.8, Segmentation: Segment{box(1, 1, 10, 10)}},
+		{ImageID: 1, CategoryID: 2, Score: 0.7, Segmentation: Segment{box(1, 1, 10, 10)}},
+		{ImageID: 1, CategoryID: 1, Score: 0.6, Segmentation: Segment{box(12, 12, 20, 20)}},
+	}
+	out, err := PostProcess(results, PostProcessOptions{Method: NMSHard, IoUType: IoUSegm, IoUThreshold: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 3 {
+		t.Fatalf("per category NMS kept %d results, want 3", len(out))
+	}
+	out, _ = PostProcess(results, PostProcessOptions{Method: NMSHard, IoUType: IoUSegm, IoUThreshold: 0.5, ClassAgnostic: true})
+	if len(out) != 2 {
+		t.Fatalf("class agnostic NMS kept %d results, want 2", len(out))
+	}
+	out, _ = PostProcess(results, PostProcessOptions{Method: NMSMatrix, IoUType: IoUSegm, Sigma: 2})
+	if len(out) != 4 || out[len(out)-1].Score >= 0.6 {
+		t.Fatalf("matrix NMS did not decay the overlapping mask: %+v", out)
+	}
+}
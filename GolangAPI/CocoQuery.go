@@ -0,0 +1,230 @@
+package coco
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+//AnnQuery selects annotations for QueryAnnIds. Every field that is set
+//must match, unset fields match all annotations.
+type AnnQuery struct {
+	// annotations of any of these images
+	ImgIds []int
+	// annotations of any of these categories
+	CatIds []int
+	// area bounds, both inclusive like the area ranges of COCOeval
+	AreaMin, AreaMax *float32
+	// true keeps only crowd annotations, false only non-crowd annotations
+	Iscrowd *bool
+	// extra fields that must be present with these values, e.g.
+	// {"occluded": true}. Values are compared as JSON, a nil value only
+	// requires the field to be present.
+	Extra map[string]interface{}
+}
+
+// normalizeExtra converts the Extra values of q into what json.Unmarshal
+// gives for them, so 1 and 1.0 compare equal.
+func (q *AnnQuery) normalizeExtra() map[string]interface{} {
+	if len(q.Extra) == 0 {
+		return nil
+	}
+	out := make(map[string]interface{}, len(q.Extra))
+	for name, v := range q.Extra {
+		if v == nil {
+			out[name] = nil
+			continue
+		}
+		var norm interface{}
+		if data, err := json.Marshal(v); err == nil && json.Unmarshal(data, &norm) == nil {
+			out[name] = norm
+		} else {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+func matchExtra(extra map[string]json.RawMessage, want map[string]interface{}) bool {
+	for name, v := range want {
+		raw, ok := extra[name]
+		if !ok {
+			return false
+		}
+		if v == nil {
+			continue
+		}
+		var got interface{}
+		if err := json.Unmarshal(raw, &got); err != nil || !reflect.DeepEqual(got, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether ann passes the filters other than ImgIds.
+func (q *AnnQuery) match(ann *Annotation, cats map[int]bool, extra map[string]interface{}) bool {
+	if cats != nil && !cats[ann.CategoryID] {
+		return false
+	}
+	if q.AreaMin != nil && ann.Area < *q.AreaMin {
+		return false
+	}
+	if q.AreaMax != nil && ann.Area > *q.AreaMax {
+		return false
+	}
+	if q.Iscrowd != nil && (ann.Iscrowd != 0) != *q.Iscrowd {
+		return false
+	}
+	if extra != nil && !matchExtra(ann.Extra, extra) {
+		return false
+	}
+	return true
+}
+
+//QueryAnnIds Get ann ids that satisfy every filter of q, sorted ascending
+func (api *CocoApi) QueryAnnIds(q AnnQuery) []int {
+	var cats map[int]bool
+	if len(q.CatIds) > 0 {
+		cats = make(map[int]bool, len(q.CatIds))
+		for _, id := range q.CatIds {
+			cats[id] = true
+		}
+	}
+
+	extra := q.normalizeExtra()
+	ids := []int{}
+	if len(q.ImgIds) > 0 {
+		seen := make(map[int]bool)
+		for _, imgId := range q.ImgIds {
+			for _, id := range api.imgToAnnMap[imgId] {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				ann := api.annMap[id]
+				if q.match(&ann, cats, extra) {
+					ids = append(ids, id)
+				}
+			}
+		}
+	} else if cats != nil {
+		for catId := range cats {
+			for _, id := range api.catToAnnMap[catId] {
+				ann := api.annMap[id]
+				if q.match(&ann, nil, extra) {
+					ids = append(ids, id)
+				}
+			}
+		}
+	} else {
+		for id, ann := range api.annMap {
+			if q.match(&ann, nil, extra) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+//SetMode selects how the image sets of several categories are combined
+type SetMode int
+
+const (
+	// SetIntersect keeps images that contain every category like getImgIds of pycocotools
+	SetIntersect SetMode = iota
+	// SetUnion keeps images that contain any of the categories like GetImgIds
+	SetUnion
+)
+
+//GetImgIdsWith Get img ids that satisfy given filter conditions, sorted
+//ascending. The image sets of catIds are combined with mode and then
+//restricted to imgIds. Like pycocotools imgIds are returned unchecked when
+//catIds is empty, all images are returned when both are empty.
+func (api *CocoApi) GetImgIdsWith(imgIds, catIds []int, mode SetMode) []int {
+	var ids map[int]bool
+	if len(imgIds) > 0 {
+		ids = make(map[int]bool, len(imgIds))
+		for _, id := range imgIds {
+			ids[id] = true
+		}
+	}
+	if len(catIds) > 0 {
+		var cats map[int]bool
+		for i, catId := range catIds {
+			imgs := make(map[int]bool)
+			for _, id := range api.catToImgMap[catId] {
+				imgs[id] = true
+			}
+			switch {
+			case i == 0:
+				cats = imgs
+			case mode == SetUnion:
+				for id := range imgs {
+					cats[id] = true
+				}
+			default:
+				for id := range cats {
+					if !imgs[id] {
+						delete(cats, id)
+					}
+				}
+			}
+		}
+		if ids != nil {
+			for id := range ids {
+				if !cats[id] {
+					delete(ids, id)
+				}
+			}
+		} else {
+			ids = cats
+		}
+	}
+	out := []int{}
+	if ids == nil {
+		for id := range api.imgMap {
+			out = append(out, id)
+		}
+	} else {
+		for id := range ids {
+			out = append(out, id)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+//GetCatIdsWith Get cat ids that satisfy given filter conditions, sorted
+//ascending. Every non empty filter must match like getCatIds of pycocotools.
+func (api *CocoApi) GetCatIdsWith(names, supCatNames []string, catIds []int) []int {
+	nameMap := make(map[string]bool)
+	for _, name := range names {
+		nameMap[name] = true
+	}
+	superNameMap := make(map[string]bool)
+	for _, name := range supCatNames {
+		superNameMap[name] = true
+	}
+	idMap := make(map[int]bool)
+	for _, id := range catIds {
+		idMap[id] = true
+	}
+
+	ids := []int{}
+	for _, v := range api.catMap {
+		if len(names) > 0 && !nameMap[v.Name] {
+			continue
+		}
+		if len(supCatNames) > 0 && !superNameMap[v.Supercategory] {
+			continue
+		}
+		if len(catIds) > 0 && !idMap[v.ID] {
+			continue
+		}
+		ids = append(ids, v.ID)
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,151 @@
+package coco
+
+import (
+	"sort"
+	"testing"
+)
+
+func Test_QueryAnnIds(t *testing.T) {
+	api, err := NewCocoApi(datasetMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgIds := api.GetImgIds(nil)
+	sort.Ints(imgIds)
+	catIds := api.GetCatIds(nil, nil)
+	sort.Ints(catIds)
+	first := func(ids []int, n int) []int {
+		if n > len(ids) {
+			n = len(ids)
+		}
+		return ids[:n]
+	}
+	lo, hi := float32(1000), float32(50000)
+	crowd, noCrowd := true, false
+
+	queries := []AnnQuery{
+		{},
+		{ImgIds: first(imgIds, 2)},
+		{CatIds: first(catIds, 3)},
+		{AreaMin: &lo},
+		{AreaMin: &lo, AreaMax: &hi},
+		{Iscrowd: &crowd},
+		{ImgIds: first(imgIds, 3), CatIds: first(catIds, 10), AreaMax: &hi, Iscrowd: &noCrowd},
+		{ImgIds: []int{imgIds[0], imgIds[0]}},
+	}
+	for n, q := range queries {
+		images := make(map[int]bool)
+		for _, id := range q.ImgIds {
+			images[id] = true
+		}
+		cats := make(map[int]bool)
+		for _, id := range q.CatIds {
+			cats[id] = true
+		}
+		want := []int{}
+		for id, ann := range api.annMap {
+			if len(images) > 0 && !images[ann.ImageID] ||
+				len(cats) > 0 && !cats[ann.CategoryID] ||
+				q.AreaMin != nil && ann.Area < *q.AreaMin ||
+				q.AreaMax != nil && ann.Area > *q.AreaMax ||
+				q.Iscrowd != nil && (ann.Iscrowd == 1) != *q.Iscrowd {
+				continue
+			}
+			want = append(want, id)
+		}
+		sort.Ints(want)
+		if got := api.QueryAnnIds(q); !equalInts(got, want) {
+			t.Fatalf("query %d: got %d ids, expected %d", n, len(got), len(want))
+		}
+	}
+
+	// GetAnnIds filters a copy of the annotations
+	total := len(api.annMap)
+	api.GetAnnIds(nil, first(catIds, 1), nil, 3)
+	if len(api.annMap) != total {
+		t.Fatalf("GetAnnIds removed %d annotations from the index", total-len(api.annMap))
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func Test_GetImgIdsWith(t *testing.T) {
+	api := datasetMetaObj
+	imgCats := make(map[int]map[int]bool)
+	for _, ann := range api.annMap {
+		if imgCats[ann.ImageID] == nil {
+			imgCats[ann.ImageID] = make(map[int]bool)
+		}
+		imgCats[ann.ImageID][ann.CategoryID] = true
+	}
+	imgIds := api.GetImgIds(nil)
+	if !sort.IntsAreSorted(imgIds) || len(imgIds) != len(api.imgMap) {
+		t.Fatalf("GetImgIds(nil) gave %v", imgIds)
+	}
+	// categories of the first image, every one of them is in the intersection
+	var catIds []int
+	for id := range imgCats[imgIds[0]] {
+		catIds = append(catIds, id)
+	}
+	sort.Ints(catIds)
+
+	for _, mode := range []SetMode{SetIntersect, SetUnion} {
+		for _, restrict := range [][]int{nil, imgIds[:2]} {
+			var want []int
+			for _, img := range api.GetImgIds(nil) {
+				if restrict != nil && img != restrict[0] && img != restrict[1] {
+					continue
+				}
+				n := 0
+				for _, c := range catIds {
+					if imgCats[img][c] {
+						n++
+					}
+				}
+				if mode == SetIntersect && n == len(catIds) || mode == SetUnion && n > 0 {
+					want = append(want, img)
+				}
+			}
+			if got := api.GetImgIdsWith(restrict, catIds, mode); !equalInts(got, want) {
+				t.Fatalf("mode %d restrict %v: %v, expected %v", mode, restrict, got, want)
+			}
+		}
+	}
+	if got := api.GetImgIdsWith(nil, catIds, SetIntersect); len(got) == 0 {
+		t.Fatalf("intersection lost image %d", imgIds[0])
+	}
+	if got := api.GetImgIdsWith([]int{-1, imgIds[0]}, nil, SetIntersect); !equalInts(got, []int{-1, imgIds[0]}) {
+		t.Fatalf("imgIds without catIds: %v", got)
+	}
+	if got := api.GetImgIds(catIds); !equalInts(got, api.GetImgIdsWith(nil, catIds, SetUnion)) {
+		t.Fatalf("GetImgIds is not the union: %v", got)
+	}
+}
+
+func Test_GetCatIdsWith(t *testing.T) {
+	api := datasetMetaObj
+	all := api.GetCatIds(nil, nil)
+	if !sort.IntsAreSorted(all) || len(all) != len(api.catMap) {
+		t.Fatalf("GetCatIds(nil, nil) gave %d ids", len(all))
+	}
+	cat := api.catMap[all[0]]
+	if got := api.GetCatIdsWith(nil, nil, []int{all[1], all[0], -5}); !equalInts(got, []int{all[0], all[1]}) {
+		t.Fatalf("catIds filter: %v", got)
+	}
+	if got := api.GetCatIdsWith([]string{cat.Name}, nil, []int{-5}); len(got) != 0 {
+		t.Fatalf("filters must all match: %v", got)
+	}
+	if got := api.GetCatIdsWith([]string{cat.Name}, []string{cat.Supercategory}, all); !equalInts(got, []int{cat.ID}) {
+		t.Fatalf("name filter: %v", got)
+	}
+}
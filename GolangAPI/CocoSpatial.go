@@ -0,0 +1,231 @@
+package coco
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// Spatial index over annotation boxes, a static R-tree packed with the
+// Sort-Tile-Recursive algorithm.
+//  Intersecting - Ann ids whose box intersects a rectangle.
+//  Contained    - Ann ids whose box lies inside a rectangle.
+//  Covering     - Ann ids whose box covers a point.
+//  Nearest      - Ann ids of the k boxes nearest to a point.
+// Boxes are closed, boxes that only touch a rectangle intersect it.
+
+// boxNodeSize is the number of entries of a full R-tree node.
+const boxNodeSize = 16
+
+type boxEntry struct {
+	rect  [4]float32 // x1, y1, x2, y2
+	id    int
+	child *boxNode
+}
+
+type boxNode struct {
+	entries []boxEntry
+}
+
+//BoxIndex is a read-only R-tree over annotation boxes, it is safe for
+//concurrent use
+type BoxIndex struct {
+	root *boxNode
+	size int
+}
+
+func xywhToRect(b [4]float32) [4]float32 {
+	return [4]float32{b[0], b[1], b[0] + b[2], b[1] + b[3]}
+}
+
+func rectUnion(a, b [4]float32) [4]float32 {
+	return [4]float32{min32(a[0], b[0]), min32(a[1], b[1]), max32(a[2], b[2]), max32(a[3], b[3])}
+}
+
+func rectIntersects(a, b [4]float32) bool {
+	return a[0] <= b[2] && b[0] <= a[2] && a[1] <= b[3] && b[1] <= a[3]
+}
+
+// rectInside reports whether a lies inside b.
+func rectInside(a, b [4]float32) bool {
+	return a[0] >= b[0] && a[1] >= b[1] && a[2] <= b[2] && a[3] <= b[3]
+}
+
+// rectDist2 is the squared distance from (x, y) to r, 0 inside r.
+func rectDist2(r [4]float32, x, y float64) float64 {
+	dx := math.Max(math.Max(float64(r[0])-x, 0), x-float64(r[2]))
+	dy := math.Max(math.Max(float64(r[1])-y, 0), y-float64(r[3]))
+	return dx*dx + dy*dy
+}
+
+// packLevel groups entries into nodes of boxNodeSize and returns one entry
+// per node. Entries are sorted into vertical slices by the x of their
+// center and every slice is tiled by the y of the center.
+func packLevel(entries []boxEntry) []boxEntry {
+	nodes := (len(entries) + boxNodeSize - 1) / boxNodeSize
+	slices := int(math.Ceil(math.Sqrt(float64(nodes))))
+	sliceSize := slices * boxNodeSize
+	center := func(e boxEntry, k int) float32 { return e.rect[k] + e.rect[k+2] }
+	sort.SliceStable(entries, func(i, j int) bool { return center(entries[i], 0) < center(entries[j], 0) })
+
+	var parents []boxEntry
+	for s := 0; s < len(entries); s += sliceSize {
+		slice := entries[s:minInt(s+sliceSize, len(entries))]
+		sort.SliceStable(slice, func(i, j int) bool { return center(slice[i], 1) < center(slice[j], 1) })
+		for n := 0; n < len(slice); n += boxNodeSize {
+			node := &boxNode{entries: append([]boxEntry(nil), slice[n:minInt(n+boxNodeSize, len(slice))]...)}
+			rect := node.entries[0].rect
+			for _, e := range node.entries[1:] {
+				rect = rectUnion(rect, e.rect)
+			}
+			parents = append(parents, boxEntry{rect: rect, child: node})
+		}
+	}
+	return parents
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+//NewBoxIndex builds an index over the Bbox of anns
+func NewBoxIndex(anns []Annotation) *BoxIndex {
+	ix := &BoxIndex{size: len(anns)}
+	if len(anns) == 0 {
+		return ix
+	}
+	level := make([]boxEntry, len(anns))
+	for i, ann := range anns {
+		level[i] = boxEntry{rect: xywhToRect(ann.Bbox), id: ann.ID}
+	}
+	for len(level) > boxNodeSize {
+		level = packLevel(level)
+	}
+	ix.root = &boxNode{entries: level}
+	return ix
+}
+
+//Len Get the number of indexed boxes
+func (ix *BoxIndex) Len() int {
+	return ix.size
+}
+
+// search reports the ids of all leaves whose rect passes leaf, descending
+// into the nodes whose rect passes node.
+func (ix *BoxIndex) search(node func(r [4]float32) bool, leaf func(r [4]float32) bool) []int {
+	ids := []int{}
+	if ix.root == nil {
+		return ids
+	}
+	stack := []*boxNode{ix.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, e := range n.entries {
+			if e.child != nil {
+				if node(e.rect) {
+					stack = append(stack, e.child)
+				}
+			} else if leaf(e.rect) {
+				ids = append(ids, e.id)
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+//Intersecting Get the ids of the boxes that intersect the [x, y, w, h]
+//rectangle r, sorted ascending
+func (ix *BoxIndex) Intersecting(r [4]float32) []int {
+	q := xywhToRect(r)
+	hit := func(b [4]float32) bool { return rectIntersects(b, q) }
+	return ix.search(hit, hit)
+}
+
+//Contained Get the ids of the boxes that lie inside the [x, y, w, h]
+//rectangle r, sorted ascending
+func (ix *BoxIndex) Contained(r [4]float32) []int {
+	q := xywhToRect(r)
+	return ix.search(
+		func(b [4]float32) bool { return rectIntersects(b, q) },
+		func(b [4]float32) bool { return rectInside(b, q) },
+	)
+}
+
+//Covering Get the ids of the boxes that cover the point (x, y), sorted ascending
+func (ix *BoxIndex) Covering(x, y float32) []int {
+	q := [4]float32{x, y, x, y}
+	hit := func(b [4]float32) bool { return rectIntersects(b, q) }
+	return ix.search(hit, hit)
+}
+
+type boxItem struct {
+	dist  float64
+	entry boxEntry
+}
+
+type boxQueue []boxItem
+
+func (q boxQueue) Len() int { return len(q) }
+func (q boxQueue) Less(i, j int) bool {
+	if q[i].dist != q[j].dist {
+		return q[i].dist < q[j].dist
+	}
+	// expand nodes first so that equally near boxes come out by id
+	ni, nj := q[i].entry.child != nil, q[j].entry.child != nil
+	if ni != nj {
+		return ni
+	}
+	return q[i].entry.id < q[j].entry.id
+}
+func (q boxQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *boxQueue) Push(x interface{}) { *q = append(*q, x.(boxItem)) }
+func (q *boxQueue) Pop() interface{} {
+	old := *q
+	it := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return it
+}
+
+//Nearest Get the ids of the k boxes nearest to the point (x, y) ordered by
+//distance, boxes covering the point have distance 0 and ties are ordered
+//by id
+func (ix *BoxIndex) Nearest(x, y float32, k int) []int {
+	ids := []int{}
+	if ix.root == nil || k <= 0 {
+		return ids
+	}
+	px, py := float64(x), float64(y)
+	q := &boxQueue{}
+	for _, e := range ix.root.entries {
+		heap.Push(q, boxItem{rectDist2(e.rect, px, py), e})
+	}
+	for q.Len() > 0 && len(ids) < k {
+		it := heap.Pop(q).(boxItem)
+		if it.entry.child == nil {
+			ids = append(ids, it.entry.id)
+			continue
+		}
+		for _, e := range it.entry.child.entries {
+			heap.Push(q, boxItem{rectDist2(e.rect, px, py), e})
+		}
+	}
+	return ids
+}
+
+//BoxIndex Get the spatial index over the annotations of an image, it is
+//built on first use and shared afterwards
+func (api *CocoApi) BoxIndex(imgId int) *BoxIndex {
+	api.indexMu.Lock()
+	defer api.indexMu.Unlock()
+	if ix, ok := api.boxIndexes[imgId]; ok {
+		return ix
+	}
+	ix := NewBoxIndex(api.LoadAnns(api.imgToAnnMap[imgId]))
+	api.boxIndexes[imgId] = ix
+	return ix
+}
@@ -0,0 +1,82 @@
+package coco
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func randomBoxes(rnd *rand.Rand, n int) []Annotation {
+	anns := make([]Annotation, n)
+	for i := range anns {
+		anns[i] = Annotation{ID: 1000 + i, Bbox: [4]float32{
+			float32(rnd.Intn(500)), float32(rnd.Intn(400)), float32(rnd.Intn(60)), float32(rnd.Intn(60)),
+		}}
+	}
+	return anns
+}
+
+func bruteBoxes(anns []Annotation, keep func(b [4]float32) bool) []int {
+	ids := []int{}
+	for _, ann := range anns {
+		if keep(xywhToRect(ann.Bbox)) {
+			ids = append(ids, ann.ID)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func Test_BoxIndex(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	for _, n := range []int{0, 1, 7, 16, 17, 300, 2000} {
+		anns := randomBoxes(rnd, n)
+		ix := NewBoxIndex(anns)
+		if ix.Len() != n {
+			t.Fatalf("len %d, expected %d", ix.Len(), n)
+		}
+		for it := 0; it < 50; it++ {
+			r := [4]float32{float32(rnd.Intn(500)), float32(rnd.Intn(400)), float32(rnd.Intn(150)), float32(rnd.Intn(150))}
+			q := xywhToRect(r)
+			if got, want := ix.Intersecting(r), bruteBoxes(anns, func(b [4]float32) bool { return rectIntersects(b, q) }); !equalInts(got, want) {
+				t.Fatalf("n %d intersecting %v: %v, expected %v", n, r, got, want)
+			}
+			if got, want := ix.Contained(r), bruteBoxes(anns, func(b [4]float32) bool { return rectInside(b, q) }); !equalInts(got, want) {
+				t.Fatalf("n %d contained %v: %v, expected %v", n, r, got, want)
+			}
+			x, y := r[0], r[1]
+			p := [4]float32{x, y, x, y}
+			if got, want := ix.Covering(x, y), bruteBoxes(anns, func(b [4]float32) bool { return rectIntersects(b, p) }); !equalInts(got, want) {
+				t.Fatalf("n %d covering %v %v: %v, expected %v", n, x, y, got, want)
+			}
+
+			k := rnd.Intn(12)
+			order := append([]Annotation(nil), anns...)
+			dist := func(a Annotation) float64 { return rectDist2(xywhToRect(a.Bbox), float64(x), float64(y)) }
+			sort.SliceStable(order, func(i, j int) bool {
+				if di, dj := dist(order[i]), dist(order[j]); di != dj {
+					return di < dj
+				}
+				return order[i].ID < order[j].ID
+			})
+			want := []int{}
+			for i := 0; i < k && i < len(order); i++ {
+				want = append(want, order[i].ID)
+			}
+			if got := ix.Nearest(x, y, k); !equalInts(got, want) {
+				t.Fatalf("n %d nearest %d to %v %v: %v, expected %v", n, k, x, y, got, want)
+			}
+		}
+	}
+
+	// the index of an image covers all of its annotations
+	img := datasetMetaObj.GetImgIds(nil)[0]
+	ix := datasetMetaObj.BoxIndex(img)
+	if ix != datasetMetaObj.BoxIndex(img) {
+		t.Fatal("box index is rebuilt")
+	}
+	all := ix.Intersecting([4]float32{-1e6, -1e6, 2e6, 2e6})
+	if !equalInts(all, datasetMetaObj.ImgToAnns(img)) {
+		t.Fatalf("index holds %v, image has %v", all, datasetMetaObj.ImgToAnns(img))
+	}
+}
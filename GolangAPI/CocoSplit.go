@@ -0,0 +1,265 @@
+package coco
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Splitting images into train/val/test or k folds with iterative
+// stratification (Sechidis et al. 2011). Images are first grouped, then
+// the category with the fewest unassigned instances is taken in turn and
+// every group containing it goes to the split that still needs most of its
+// instances, ties go to the split that needs most images. Groups without
+// annotations fill up the image counts at the end.
+
+//Split is a named part of a dataset, ratios are relative to their sum
+type Split struct {
+	Name  string
+	Ratio float64
+}
+
+//SplitOptions configures SplitDataset and KFold
+type SplitOptions struct {
+	// seeds the order in which groups are assigned
+	Seed int64
+	// images with the same non empty group stay in one split, e.g.
+	// GroupByExtra("video_id"). nil puts every image in its own group.
+	GroupBy func(img Image) string
+}
+
+//GroupByExtra groups images by the value of an extra field, strings group
+//by their text and other values by their json. Images where the field is
+//missing or null are not grouped.
+func GroupByExtra(field string) func(img Image) string {
+	return func(img Image) string {
+		var v interface{}
+		if err := json.Unmarshal(img.Extra[field], &v); err != nil || v == nil {
+			return ""
+		}
+		if s, ok := v.(string); ok {
+			return s
+		}
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+//GroupByFilePrefix groups images by their file name up to the last sep,
+//e.g. "_" keeps v1_0001.jpg and v1_0002.jpg together
+func GroupByFilePrefix(sep string) func(img Image) string {
+	return func(img Image) string {
+		if i := strings.LastIndex(img.FileName, sep); i > 0 {
+			return img.FileName[:i]
+		}
+		return ""
+	}
+}
+
+//SplitStats describes one split
+type SplitStats struct {
+	Name   string
+	ImgIds []int
+	// number of annotations of every category id
+	Instances   map[int]int
+	Annotations int
+}
+
+//SplitReport shows how well the splits are balanced
+type SplitReport struct {
+	Splits []SplitStats
+	// largest difference between the share of a category's instances in a
+	// split and the ratio of the split, by category id
+	Deviation    map[int]float64
+	MaxDeviation float64
+}
+
+type splitGroup struct {
+	imgs   []int
+	counts map[int]int
+}
+
+// splitGroups collects the images of every group in dataset order.
+func (api *CocoApi) splitGroups(groupBy func(img Image) string) []*splitGroup {
+	var groups []*splitGroup
+	byKey := make(map[string]*splitGroup)
+	for _, img := range api.datasetMeta.Images {
+		if _, ok := api.imgMap[img.ID]; !ok {
+			continue
+		}
+		key := ""
+		if groupBy != nil {
+			key = groupBy(img)
+		}
+		g := byKey[key]
+		if g == nil || key == "" {
+			g = &splitGroup{counts: make(map[int]int)}
+			groups = append(groups, g)
+			if key != "" {
+				byKey[key] = g
+			}
+		}
+		g.imgs = append(g.imgs, img.ID)
+		for _, id := range api.imgToAnnMap[img.ID] {
+			g.counts[api.annMap[id].CategoryID]++
+		}
+	}
+	return groups
+}
+
+// stratify returns the split of every group.
+func stratify(groups []*splitGroup, ratios []float64, seed int64) []int {
+	rand.New(rand.NewSource(seed)).Shuffle(len(groups), func(i, j int) {
+		groups[i], groups[j] = groups[j], groups[i]
+	})
+	remaining := make(map[int]int)
+	groupsOf := make(map[int][]int)
+	totalImgs := 0
+	for i, g := range groups {
+		totalImgs += len(g.imgs)
+		for c, n := range g.counts {
+			remaining[c] += n
+			groupsOf[c] = append(groupsOf[c], i)
+		}
+	}
+	desired := make([]map[int]float64, len(ratios))
+	desiredImgs := make([]float64, len(ratios))
+	for j, r := range ratios {
+		desired[j] = make(map[int]float64, len(remaining))
+		for c, n := range remaining {
+			desired[j][c] = r * float64(n)
+		}
+		desiredImgs[j] = r * float64(totalImgs)
+	}
+	cats := make([]int, 0, len(remaining))
+	for c := range remaining {
+		cats = append(cats, c)
+	}
+	sort.Ints(cats)
+
+	split := make([]int, len(groups))
+	for i := range split {
+		split[i] = -1
+	}
+	assign := func(i, j int) {
+		split[i] = j
+		for c, n := range groups[i].counts {
+			desired[j][c] -= float64(n)
+			remaining[c] -= n
+		}
+		desiredImgs[j] -= float64(len(groups[i].imgs))
+	}
+	best := func(c int, byCat bool) int {
+		b := 0
+		for j := 1; j < len(ratios); j++ {
+			if byCat && desired[j][c] != desired[b][c] {
+				if desired[j][c] > desired[b][c] {
+					b = j
+				}
+				continue
+			}
+			if desiredImgs[j] > desiredImgs[b] {
+				b = j
+			}
+		}
+		return b
+	}
+
+	for {
+		c := -1
+		for _, k := range cats {
+			if remaining[k] > 0 && (c < 0 || remaining[k] < remaining[c]) {
+				c = k
+			}
+		}
+		if c < 0 {
+			break
+		}
+		for _, i := range groupsOf[c] {
+			if split[i] < 0 {
+				assign(i, best(c, true))
+			}
+		}
+	}
+	for i := range groups {
+		if split[i] < 0 {
+			assign(i, best(0, false))
+		}
+	}
+	return split
+}
+
+//SplitDataset divides the images into splits balancing the instances of
+//every category, it returns the dataset of every split with all categories
+func (api *CocoApi) SplitDataset(splits []Split, opts SplitOptions) ([]CocoData, SplitReport, error) {
+	if len(splits) == 0 {
+		return nil, SplitReport{}, errors.New("no splits")
+	}
+	sum := 0.0
+	for _, s := range splits {
+		if !(s.Ratio > 0) || math.IsInf(s.Ratio, 0) {
+			return nil, SplitReport{}, errors.New("split ratios must be positive")
+		}
+		sum += s.Ratio
+	}
+	ratios := make([]float64, len(splits))
+	for j, s := range splits {
+		ratios[j] = s.Ratio / sum
+	}
+
+	groups := api.splitGroups(opts.GroupBy)
+	assigned := stratify(groups, ratios, opts.Seed)
+
+	report := SplitReport{Splits: make([]SplitStats, len(splits)), Deviation: make(map[int]float64)}
+	totals := make(map[int]int)
+	for j, s := range splits {
+		report.Splits[j] = SplitStats{Name: s.Name, ImgIds: []int{}, Instances: make(map[int]int)}
+	}
+	for i, g := range groups {
+		st := &report.Splits[assigned[i]]
+		st.ImgIds = append(st.ImgIds, g.imgs...)
+		for c, n := range g.counts {
+			st.Instances[c] += n
+			st.Annotations += n
+			totals[c] += n
+		}
+	}
+	for c, total := range totals {
+		for j, st := range report.Splits {
+			d := math.Abs(float64(st.Instances[c])/float64(total) - ratios[j])
+			if d > report.Deviation[c] {
+				report.Deviation[c] = d
+			}
+		}
+		report.MaxDeviation = math.Max(report.MaxDeviation, report.Deviation[c])
+	}
+
+	datas := make([]CocoData, len(splits))
+	for j := range report.Splits {
+		st := &report.Splits[j]
+		sort.Ints(st.ImgIds)
+		imgSet := make(map[int]bool, len(st.ImgIds))
+		for _, id := range st.ImgIds {
+			imgSet[id] = true
+		}
+		datas[j] = api.subset(imgSet, nil, SubsetOptions{KeepEmptyImages: true, KeepEmptyCategories: true})
+	}
+	return datas, report, nil
+}
+
+//KFold divides the images into k balanced folds named fold0 to fold<k-1>,
+//the training set of a fold is the union of the others
+func (api *CocoApi) KFold(k int, opts SplitOptions) ([]CocoData, SplitReport, error) {
+	if k < 2 {
+		return nil, SplitReport{}, errors.New("k must be at least 2")
+	}
+	splits := make([]Split, k)
+	for i := range splits {
+		splits[i] = Split{fmt.Sprintf("fold%d", i), 1}
+	}
+	return api.SplitDataset(splits, opts)
+}
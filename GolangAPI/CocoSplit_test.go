@@ -0,0 +1,145 @@
+package coco
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// splitApi builds 400 images in videos of 1 to 4 frames with skewed
+// category frequencies.
+func splitApi(t *testing.T) *CocoApi {
+	api, err := NewCocoApi([]byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for c := 1; c <= 6; c++ {
+		api.AddCategory(Categories{ID: c, Name: fmt.Sprint("c", c)})
+	}
+	rnd := rand.New(rand.NewSource(49))
+	video := 0
+	for len(api.imgMap) < 400 {
+		video++
+		frames := 1 + rnd.Intn(4)
+		for f := 0; f < frames; f++ {
+			img, _ := api.AddImage(Image{
+				FileName: fmt.Sprintf("v%d_%04d.jpg", video, f),
+				Extra:    map[string]json.RawMessage{"video_id": json.RawMessage(fmt.Sprint(video))},
+			})
+			for c := 1; c <= 6; c++ {
+				// category c appears in about one image out of 2^(c-1)
+				if rnd.Intn(1<<uint(c-1)) == 0 {
+					for n := rnd.Intn(3); n >= 0; n-- {
+						api.AddAnnotation(Annotation{ImageID: img, CategoryID: c})
+					}
+				}
+			}
+		}
+	}
+	return api
+}
+
+func Test_SplitDataset(t *testing.T) {
+	api := splitApi(t)
+	splits := []Split{{"train", 8}, {"val", 1}, {"test", 1}}
+	for _, groupBy := range []func(Image) string{nil, GroupByExtra("video_id"), GroupByFilePrefix("_")} {
+		datas, report, err := api.SplitDataset(splits, SplitOptions{Seed: 1, GroupBy: groupBy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.MaxDeviation > 0.06 {
+			t.Errorf("categories are not balanced: %v", report.Deviation)
+		}
+		seen := make(map[int]string)
+		for j, data := range datas {
+			st := report.Splits[j]
+			if st.Name != splits[j].Name || len(data.Images) != len(st.ImgIds) || len(data.Annotations) != st.Annotations {
+				t.Fatalf("split %s: %d images %d annotations, report %+v", st.Name, len(data.Images), len(data.Annotations), st)
+			}
+			if len(data.Categories) != 6 {
+				t.Fatalf("split %s has %d categories", st.Name, len(data.Categories))
+			}
+			ratio := float64(len(data.Images)) / 400
+			if want := splits[j].Ratio / 10; ratio < want-0.03 || ratio > want+0.03 {
+				t.Errorf("split %s has %.3f of the images", st.Name, ratio)
+			}
+			for _, img := range data.Images {
+				if s, ok := seen[img.ID]; ok {
+					t.Fatalf("image %d in %s and %s", img.ID, s, st.Name)
+				}
+				seen[img.ID] = st.Name
+			}
+		}
+		if len(seen) != len(api.imgMap) {
+			t.Fatalf("%d of %d images assigned", len(seen), len(api.imgMap))
+		}
+		if groupBy != nil {
+			videos := make(map[string]string)
+			for id, split := range seen {
+				v := string(api.imgMap[id].Extra["video_id"])
+				if s, ok := videos[v]; ok && s != split {
+					t.Fatalf("video %s in %s and %s", v, s, split)
+				}
+				videos[v] = split
+			}
+		}
+
+		_, again, _ := api.SplitDataset(splits, SplitOptions{Seed: 1, GroupBy: groupBy})
+		if !reflect.DeepEqual(again, report) {
+			t.Fatal("the same seed gives a different split")
+		}
+	}
+
+	if _, _, err := api.SplitDataset([]Split{{"train", 1}, {"val", 0}}, SplitOptions{}); err == nil {
+		t.Error("expected an error for a zero ratio")
+	}
+	if _, _, err := api.SplitDataset(nil, SplitOptions{}); err == nil {
+		t.Error("expected an error without splits")
+	}
+}
+
+func Test_KFold(t *testing.T) {
+	api := splitApi(t)
+	datas, report, err := api.KFold(5, SplitOptions{Seed: 7, GroupBy: GroupByExtra("video_id")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(datas) != 5 || report.Splits[4].Name != "fold4" {
+		t.Fatalf("folds %+v", report.Splits)
+	}
+	if report.MaxDeviation > 0.06 {
+		t.Errorf("folds are not balanced: %v", report.Deviation)
+	}
+	total := 0
+	for _, data := range datas {
+		total += len(data.Annotations)
+	}
+	if total != len(api.annMap) {
+		t.Fatalf("%d of %d annotations in the folds", total, len(api.annMap))
+	}
+	if _, _, err := api.KFold(1, SplitOptions{}); err == nil {
+		t.Error("expected an error for one fold")
+	}
+}
+
+func Test_GroupByExtra(t *testing.T) {
+	group := GroupByExtra("video")
+	for raw, want := range map[string]string{
+		`"v1"`:       "v1",
+		`12`:         "12",
+		` 12.0 `:     "12",
+		`{"a": [1]}`: `{"a":[1]}`,
+		`null`:       "",
+		``:           "",
+	} {
+		img := Image{Extra: map[string]json.RawMessage{"video": json.RawMessage(raw)}}
+		if got := group(img); got != want {
+			t.Errorf("%q: got %q, want %q", raw, got, want)
+		}
+	}
+	if got := group(Image{}); got != "" {
+		t.Errorf("missing field grouped as %q", got)
+	}
+}
@@ -0,0 +1,121 @@
+package coco
+
+//SubsetOptions configures Subset
+type SubsetOptions struct {
+	// keep the selected images that have no annotation in the subset
+	KeepEmptyImages bool
+	// keep the selected categories that have no annotation in the subset
+	KeepEmptyCategories bool
+	// number images, annotations, categories and licenses from 1 in the
+	// order of the dataset
+	Renumber bool
+}
+
+func idSet(ids []int) map[int]bool {
+	if len(ids) == 0 {
+		return nil
+	}
+	set := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+//Subset Get the annotations of the given categories on the given images
+//together with the images, categories and licenses they reference. Empty
+//imgIds or catIds select all images or categories, annotations without a
+//category like captions are only kept when catIds is empty. The order of
+//the dataset is kept, the info and extra fields are copied.
+func (api *CocoApi) Subset(imgIds, catIds []int, opts SubsetOptions) CocoData {
+	return api.subset(idSet(imgIds), idSet(catIds), opts)
+}
+
+// subset is Subset with nil sets selecting everything, an empty set selects
+// nothing.
+func (api *CocoApi) subset(imgSet, catSet map[int]bool, opts SubsetOptions) CocoData {
+	data := api.datasetMeta
+	out := CocoData{Info: data.Info, Extra: data.Extra}
+
+	usedImgs := make(map[int]bool)
+	usedCats := make(map[int]bool)
+	for _, ann := range data.Annotations {
+		if imgSet != nil && !imgSet[ann.ImageID] {
+			continue
+		}
+		if _, ok := api.imgMap[ann.ImageID]; !ok {
+			continue
+		}
+		if catSet != nil && !catSet[ann.CategoryID] {
+			continue
+		}
+		out.Annotations = append(out.Annotations, ann)
+		usedImgs[ann.ImageID] = true
+		usedCats[ann.CategoryID] = true
+		for _, info := range ann.SegmentsInfo {
+			usedCats[info.CategoryID] = true
+		}
+	}
+
+	usedLics := make(map[int]bool)
+	for _, img := range data.Images {
+		if usedImgs[img.ID] || opts.KeepEmptyImages && (imgSet == nil || imgSet[img.ID]) {
+			out.Images = append(out.Images, img)
+			usedLics[img.License] = true
+		}
+	}
+	for _, cat := range data.Categories {
+		if usedCats[cat.ID] || opts.KeepEmptyCategories && (catSet == nil || catSet[cat.ID]) {
+			out.Categories = append(out.Categories, cat)
+		}
+	}
+	for _, lic := range data.Licenses {
+		if usedLics[lic.ID] {
+			out.Licenses = append(out.Licenses, lic)
+		}
+	}
+
+	if opts.Renumber {
+		renumberDataset(&out)
+	}
+	return out
+}
+
+// renumberDataset numbers the entries of data from 1 in their order and
+// rewrites the references, the slices must not be shared.
+func renumberDataset(data *CocoData) {
+	imgMap, catMap, licMap := make(map[int]int), make(map[int]int), make(map[int]int)
+	for i := range data.Licenses {
+		licMap[data.Licenses[i].ID] = i + 1
+		data.Licenses[i].ID = i + 1
+	}
+	for i := range data.Categories {
+		catMap[data.Categories[i].ID] = i + 1
+		data.Categories[i].ID = i + 1
+	}
+	for i := range data.Images {
+		imgMap[data.Images[i].ID] = i + 1
+		data.Images[i].ID = i + 1
+		if id, ok := licMap[data.Images[i].License]; ok {
+			data.Images[i].License = id
+		}
+	}
+	for i := range data.Annotations {
+		ann := &data.Annotations[i]
+		ann.ID = i + 1
+		ann.ImageID = imgMap[ann.ImageID]
+		if id, ok := catMap[ann.CategoryID]; ok {
+			ann.CategoryID = id
+		}
+		if len(ann.SegmentsInfo) > 0 {
+			infos := make([]PSSegmentInfo, len(ann.SegmentsInfo))
+			for k, info := range ann.SegmentsInfo {
+				if id, ok := catMap[info.CategoryID]; ok {
+					info.CategoryID = id
+				}
+				infos[k] = info
+			}
+			ann.SegmentsInfo = infos
+		}
+	}
+}
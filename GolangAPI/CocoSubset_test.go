@@ -0,0 +1,135 @@
+package coco
+
+import (
+	"testing"
+)
+
+const subsetDataset = `{
+	"info": {"description": "subset"},
+	"licenses": [{"id": 4, "name": "a"}, {"id": 7, "name": "b"}, {"id": 9, "name": "c"}],
+	"images": [
+		{"id": 10, "file_name": "a.jpg", "license": 7},
+		{"id": 20, "file_name": "b.jpg", "license": 9},
+		{"id": 30, "file_name": "c.jpg", "license": 4}
+	],
+	"categories": [
+		{"id": 3, "name": "person"},
+		{"id": 5, "name": "car"},
+		{"id": 8, "name": "dog"}
+	],
+	"annotations": [
+		{"id": 100, "image_id": 10, "category_id": 5},
+		{"id": 101, "image_id": 10, "category_id": 3},
+		{"id": 102, "image_id": 20, "category_id": 3},
+		{"id": 103, "image_id": 20, "category_id": 8}
+	]
+}`
+
+// checkSubset verifies that every reference of data resolves and that
+// every image, category and license is referenced or allowed to be empty.
+func checkSubset(t *testing.T, data CocoData, opts SubsetOptions) {
+	imgs, cats, lics := make(map[int]int), make(map[int]int), make(map[int]int)
+	for _, img := range data.Images {
+		imgs[img.ID] = 0
+	}
+	for _, cat := range data.Categories {
+		cats[cat.ID] = 0
+	}
+	for _, lic := range data.Licenses {
+		lics[lic.ID] = 0
+	}
+	for _, ann := range data.Annotations {
+		if _, ok := imgs[ann.ImageID]; !ok {
+			t.Fatalf("annotation %d references missing image %d", ann.ID, ann.ImageID)
+		}
+		if _, ok := cats[ann.CategoryID]; !ok {
+			t.Fatalf("annotation %d references missing category %d", ann.ID, ann.CategoryID)
+		}
+		imgs[ann.ImageID]++
+		cats[ann.CategoryID]++
+	}
+	for _, img := range data.Images {
+		if _, ok := lics[img.License]; !ok && img.License != 0 {
+			t.Fatalf("image %d references missing license %d", img.ID, img.License)
+		}
+		lics[img.License]++
+	}
+	for id, n := range imgs {
+		if n == 0 && !opts.KeepEmptyImages {
+			t.Fatalf("image %d has no annotation", id)
+		}
+	}
+	for id, n := range cats {
+		if n == 0 && !opts.KeepEmptyCategories {
+			t.Fatalf("category %d has no annotation", id)
+		}
+	}
+	for id, n := range lics {
+		if n == 0 {
+			t.Fatalf("license %d is not used", id)
+		}
+	}
+}
+
+func Test_Subset(t *testing.T) {
+	api, err := NewCocoApi([]byte(subsetDataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	annIds := func(data CocoData) []int {
+		ids := []int{}
+		for _, ann := range data.Annotations {
+			ids = append(ids, ann.ID)
+		}
+		return ids
+	}
+	imgIds := func(data CocoData) []int {
+		ids := []int{}
+		for _, img := range data.Images {
+			ids = append(ids, img.ID)
+		}
+		return ids
+	}
+
+	data := api.Subset(nil, []int{3}, SubsetOptions{})
+	checkSubset(t, data, SubsetOptions{})
+	if !equalInts(annIds(data), []int{101, 102}) || !equalInts(imgIds(data), []int{10, 20}) || len(data.Categories) != 1 || len(data.Licenses) != 2 {
+		t.Fatalf("person subset %+v", data)
+	}
+	if data.Info.Description != "subset" {
+		t.Errorf("info %+v", data.Info)
+	}
+
+	opts := SubsetOptions{KeepEmptyImages: true, KeepEmptyCategories: true}
+	data = api.Subset([]int{10, 30}, []int{5, 8}, opts)
+	checkSubset(t, data, opts)
+	if !equalInts(annIds(data), []int{100}) || !equalInts(imgIds(data), []int{10, 30}) || len(data.Categories) != 2 || len(data.Licenses) != 2 {
+		t.Fatalf("subset with empty entries %+v", data)
+	}
+
+	opts = SubsetOptions{Renumber: true}
+	data = api.Subset([]int{20, 30}, nil, opts)
+	checkSubset(t, data, opts)
+	if !equalInts(annIds(data), []int{1, 2}) || !equalInts(imgIds(data), []int{1}) {
+		t.Fatalf("renumbered %+v", data)
+	}
+	if data.Annotations[0].CategoryID != 1 || data.Annotations[1].CategoryID != 2 || data.Images[0].License != 1 || data.Licenses[0].Name != "c" {
+		t.Fatalf("renumbered references %+v", data)
+	}
+	if api.imgMap[20].ID != 20 || api.annMap[102].CategoryID != 3 {
+		t.Fatal("renumbering changed the dataset")
+	}
+}
+
+func Test_SubsetLimitDataset(t *testing.T) {
+	// the selection of Test_createLimitDataset
+	cats := datasetMetaObj.GetCatIds([]string{"banner", "branch", "cabinet", "ceiling-other"}, []string{"building", "furniture-stuff", "ceiling"})
+	data := datasetMetaObj.Subset(nil, cats, SubsetOptions{})
+	checkSubset(t, data, SubsetOptions{})
+	if want := datasetMetaObj.GetAnnIds(nil, cats, nil, 2); len(data.Annotations) != len(want) {
+		t.Fatalf("%d annotations, expected %d", len(data.Annotations), len(want))
+	}
+	if want := datasetMetaObj.GetImgIds(cats); len(data.Images) != len(want) {
+		t.Fatalf("%d images, expected %d", len(data.Images), len(want))
+	}
+}
@@ -0,0 +1,234 @@
+package coco
+
+import (
+	"errors"
+	"sort"
+)
+
+// Editing the categories of a dataset. The operations that change category
+// ids rewrite the annotations and return the mapping, which can be applied
+// to results of a model trained on the old taxonomy.
+//  MergeCategories    - Move the annotations of several categories to one.
+//  DropCategories     - Remove categories, with or without their annotations.
+//  RenameCategory     - Change the name of a category.
+//  SetSupercategory   - Change the supercategory of categories.
+//  RenumberCategories - Number the categories contiguously from 0 or 1.
+
+//CategoryDropped is the new id of a removed category in a CategoryMapping
+const CategoryDropped = -1
+
+//CategoryMapping maps old category ids to new ones, missing ids are unchanged
+type CategoryMapping map[int]int
+
+func (m CategoryMapping) get(id int) int {
+	if n, ok := m[id]; ok {
+		return n
+	}
+	return id
+}
+
+// mapSegments rewrites the category ids of panoptic segments, segments of
+// dropped categories are removed.
+func (m CategoryMapping) mapSegments(infos []PSSegmentInfo) []PSSegmentInfo {
+	if len(infos) == 0 {
+		return infos
+	}
+	out := make([]PSSegmentInfo, 0, len(infos))
+	for _, info := range infos {
+		if info.CategoryID = m.get(info.CategoryID); info.CategoryID != CategoryDropped {
+			out = append(out, info)
+		}
+	}
+	return out
+}
+
+//Apply Get results with rewritten category ids, results of dropped
+//categories are removed
+func (m CategoryMapping) Apply(results []Annotation) []Annotation {
+	out := make([]Annotation, 0, len(results))
+	for _, r := range results {
+		if r.CategoryID = m.get(r.CategoryID); r.CategoryID == CategoryDropped {
+			continue
+		}
+		r.SegmentsInfo = m.mapSegments(r.SegmentsInfo)
+		out = append(out, r)
+	}
+	return out
+}
+
+//Then Get the mapping that applies m and then n
+func (m CategoryMapping) Then(n CategoryMapping) CategoryMapping {
+	out := make(CategoryMapping, len(m)+len(n))
+	for id, to := range n {
+		if _, ok := m[id]; !ok {
+			out[id] = to
+		}
+	}
+	for id, to := range m {
+		if to != CategoryDropped {
+			to = n.get(to)
+		}
+		out[id] = to
+	}
+	return out
+}
+
+func (api *CocoApi) checkCats(ids []int) error {
+	for _, id := range ids {
+		if _, ok := api.catMap[id]; !ok {
+			return errors.New("category not found")
+		}
+	}
+	return nil
+}
+
+// recategorize replaces the categories, rewrites the category ids of the
+// annotations with m and rebuilds the maps. Annotations of dropped
+// categories lose their category, their ids are returned in ascending order.
+// Boxes, captions and segmentations do not change, so the lazy indexes and
+// caches stay valid.
+func (api *CocoApi) recategorize(cats []Categories, m CategoryMapping) []int {
+	api.datasetMeta.Categories = cats
+	anns := api.datasetMeta.Annotations
+	uncategorized := []int{}
+	for i := range anns {
+		if anns[i].CategoryID = m.get(anns[i].CategoryID); anns[i].CategoryID == CategoryDropped {
+			anns[i].CategoryID = 0
+			uncategorized = append(uncategorized, anns[i].ID)
+		}
+		anns[i].SegmentsInfo = m.mapSegments(anns[i].SegmentsInfo)
+	}
+	api.createIndex()
+	sort.Ints(uncategorized)
+	return uncategorized
+}
+
+//MergeCategories moves the annotations of the categories ids to the category
+//target and removes them, target keeps its id and fields
+func (api *CocoApi) MergeCategories(ids []int, target int) (CategoryMapping, error) {
+	if err := api.checkCats(append([]int{target}, ids...)); err != nil {
+		return nil, err
+	}
+	m := make(CategoryMapping)
+	for _, id := range ids {
+		m[id] = target
+	}
+	var cats []Categories
+	for _, cat := range api.datasetMeta.Categories {
+		if _, ok := m[cat.ID]; !ok || cat.ID == target {
+			cats = append(cats, cat)
+		}
+	}
+	api.recategorize(cats, m)
+	return m, nil
+}
+
+//DropCategories removes categories. Their annotations are removed too
+//unless keepAnns is set, then they are kept with category 0 and their ids
+//are returned. UpdateAnnotation rejects those until they get a category
+//again. Panoptic segments of the categories are always removed.
+func (api *CocoApi) DropCategories(ids []int, keepAnns bool) (CategoryMapping, []int, error) {
+	if err := api.checkCats(ids); err != nil {
+		return nil, nil, err
+	}
+	m := make(CategoryMapping)
+	for _, id := range ids {
+		m[id] = CategoryDropped
+	}
+	if !keepAnns {
+		var annIds []int
+		for id := range m {
+			annIds = append(annIds, api.catToAnnMap[id]...)
+		}
+		api.removeAnns(annIds)
+	}
+	var cats []Categories
+	for _, cat := range api.datasetMeta.Categories {
+		if _, ok := m[cat.ID]; !ok {
+			cats = append(cats, cat)
+		}
+	}
+	return m, api.recategorize(cats, m), nil
+}
+
+//RenameCategory changes the name of a category, ids do not change
+func (api *CocoApi) RenameCategory(id int, name string) error {
+	cat, ok := api.catMap[id]
+	if !ok {
+		return errors.New("category not found")
+	}
+	cat.Name = name
+	return api.UpdateCategory(cat)
+}
+
+//SetSupercategory changes the supercategory of categories, ids do not change
+func (api *CocoApi) SetSupercategory(ids []int, supercategory string) error {
+	if err := api.checkCats(ids); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		cat := api.catMap[id]
+		cat.Supercategory = supercategory
+		if err := api.UpdateCategory(cat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//RenumberCategories numbers the categories from start, usually 0 or 1, in
+//the order of their old ids. Categories that annotations or segments refer
+//to but that do not exist are mapped to CategoryDropped, so they can not
+//collide with the new ids, and the ids of the annotations that lose their
+//category this way are returned. From 0 on the first category shares its
+//id with annotations without a category such as panoptic results, so it
+//fails when the dataset has such annotations. Categories added later get
+//ids after the largest id the dataset ever used.
+func (api *CocoApi) RenumberCategories(start int) (CategoryMapping, []int, error) {
+	if start < 0 {
+		return nil, nil, errors.New("start must not be negative")
+	}
+	ids := make([]int, 0, len(api.catMap))
+	for id := range api.catMap {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	m := make(CategoryMapping, len(ids))
+	for i, id := range ids {
+		m[id] = start + i
+	}
+	// annotations that have or will get category 0 without it existing
+	uncategorized := false
+	if _, ok := api.catMap[0]; !ok {
+		uncategorized = len(api.catToAnnMap[0]) > 0
+	}
+	orphan := func(id int) bool {
+		if _, ok := api.catMap[id]; ok || id == 0 {
+			return false
+		}
+		m[id] = CategoryDropped
+		return true
+	}
+	for _, ann := range api.datasetMeta.Annotations {
+		if orphan(ann.CategoryID) {
+			uncategorized = true
+		}
+		// segments of dropped categories are removed
+		for _, info := range ann.SegmentsInfo {
+			orphan(info.CategoryID)
+		}
+	}
+	if start == 0 && len(ids) > 0 && uncategorized {
+		return nil, nil, errors.New("annotations without a category")
+	}
+	cats := make([]Categories, len(api.datasetMeta.Categories))
+	for i, cat := range api.datasetMeta.Categories {
+		cat.ID = m[cat.ID]
+		cats[i] = cat
+	}
+	dropped := api.recategorize(cats, m)
+	if len(ids) > 0 {
+		api.lastCatId = maxInt(api.lastCatId, start+len(ids)-1)
+	}
+	return m, dropped, nil
+}
@@ -0,0 +1,154 @@
+package coco
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_TaxonomyEdits(t *testing.T) {
+	api, err := NewCocoApi([]byte(filterDataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []Annotation{
+		{ImageID: 1, CategoryID: 1, Score: 0.9},
+		{ImageID: 1, CategoryID: 2, Score: 0.8},
+		{ImageID: 2, CategoryID: 3, Score: 0.7},
+		{ImageID: 2, SegmentsInfo: []PSSegmentInfo{{ID: 1, CategoryID: 3}, {ID: 2, CategoryID: 1}}},
+	}
+
+	// bus and car become vehicle
+	vehicle, _ := api.AddCategory(Categories{Name: "vehicle"})
+	merge, err := api.MergeCategories([]int{2, 3}, vehicle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(merge, CategoryMapping{2: 4, 3: 4}) {
+		t.Fatalf("merge mapping %v", merge)
+	}
+	checkRebuilt(t, api, "merge")
+	if got := api.CatToAnns(vehicle); !equalInts(got, []int{2, 4, 5}) {
+		t.Fatalf("vehicle annotations %v", got)
+	}
+	if ids := api.GetCatIds(nil, nil); !equalInts(ids, []int{1, 4}) {
+		t.Fatalf("categories after merge %v", ids)
+	}
+
+	if err := api.RenameCategory(1, "pedestrian"); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.SetSupercategory([]int{1, 4}, "road"); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := api.FilterAnnIds(`cat == "pedestrian" and supercat == "road"`); !equalInts(ids, []int{1, 3}) {
+		t.Fatalf("renamed annotations %v", ids)
+	}
+	checkRebuilt(t, api, "rename")
+
+	renumber, dropped, err := api.RenumberCategories(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 0 {
+		t.Fatalf("renumber dropped the category of %v", dropped)
+	}
+	if !reflect.DeepEqual(renumber, CategoryMapping{1: 1, 4: 2}) {
+		t.Fatalf("renumber mapping %v", renumber)
+	}
+	checkRebuilt(t, api, "renumber")
+	if got := api.CatToAnns(1); !equalInts(got, []int{1, 3}) {
+		t.Fatalf("annotations of category 1 %v", got)
+	}
+	// ids are not reused, the vehicle category had id 4
+	if id, _ := api.AddCategory(Categories{Name: "dog"}); id != 5 {
+		t.Fatalf("new category id %d", id)
+	}
+
+	// the mappings rewrite results of the old taxonomy
+	mapped := merge.Then(renumber).Apply(results)
+	if mapped[0].CategoryID != 1 || mapped[1].CategoryID != 2 || mapped[2].CategoryID != 2 {
+		t.Fatalf("mapped results %+v", mapped)
+	}
+	if want := []PSSegmentInfo{{ID: 1, CategoryID: 2}, {ID: 2, CategoryID: 1}}; !reflect.DeepEqual(mapped[3].SegmentsInfo, want) {
+		t.Fatalf("mapped segments %+v", mapped[3].SegmentsInfo)
+	}
+	if results[0].CategoryID != 1 || results[3].SegmentsInfo[0].CategoryID != 3 {
+		t.Fatal("Apply changed its input")
+	}
+
+	drop, _, err := api.DropCategories([]int{1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRebuilt(t, api, "drop")
+	if len(api.annMap) != 3 || len(api.GetCatIds(nil, nil)) != 2 {
+		t.Fatalf("%d annotations %v categories after drop", len(api.annMap), api.GetCatIds(nil, nil))
+	}
+	all := merge.Then(renumber).Then(drop)
+	if got := all.Apply(results); len(got) != 3 || got[2].SegmentsInfo[0].CategoryID != 2 || len(got[2].SegmentsInfo) != 1 {
+		t.Fatalf("results after drop %+v", got)
+	}
+
+	// keeping the annotations leaves them without a category
+	_, dropped, err = api.DropCategories([]int{2}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRebuilt(t, api, "drop keeping annotations")
+	if len(api.annMap) != 3 || len(dropped) != 3 || !equalInts(dropped, api.CatToAnns(0)) {
+		t.Fatalf("dropped %v, annotations %v", dropped, api.annMap)
+	}
+	if err := api.UpdateAnnotation(api.annMap[dropped[0]]); err == nil {
+		t.Fatal("expected an error updating an annotation without a category")
+	}
+	if _, _, err := api.RenumberCategories(0); err == nil {
+		t.Fatal("expected an error for annotations without a category")
+	}
+
+	if _, err := api.MergeCategories([]int{7}, 2); err == nil {
+		t.Error("expected an error for a missing category")
+	}
+	if err := api.RenameCategory(42, "x"); err == nil {
+		t.Error("expected an error for a missing category")
+	}
+}
+
+func Test_RenumberOrphans(t *testing.T) {
+	dataset := []byte(`{
+		"images": [{"id": 1}],
+		"categories": [{"id": 5, "name": "a"}, {"id": 9, "name": "b"}],
+		"annotations": [
+			{"id": 1, "image_id": 1, "category_id": 5},
+			{"id": 2, "image_id": 1, "category_id": 7},
+			{"id": 3, "image_id": 1, "segments_info": [{"id": 1, "category_id": 7}, {"id": 2, "category_id": 9}]}
+		]
+	}`)
+	api, err := NewCocoApi(dataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := api.RenumberCategories(0); err == nil {
+		t.Fatal("expected an error for an annotation of a missing category")
+	}
+	m, dropped, err := api.RenumberCategories(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// annotation 3 has no category of its own, it only loses a segment
+	if !equalInts(dropped, []int{2}) {
+		t.Fatalf("annotations that lost their category %v", dropped)
+	}
+	if !reflect.DeepEqual(m, CategoryMapping{5: 1, 9: 2, 7: CategoryDropped}) {
+		t.Fatalf("renumber mapping %v", m)
+	}
+	checkRebuilt(t, api, "renumber orphans")
+	if got := api.CatToAnns(0); !equalInts(got, []int{2, 3}) {
+		t.Fatalf("annotations without a category %v", got)
+	}
+	if infos := api.annMap[3].SegmentsInfo; len(infos) != 1 || infos[0].CategoryID != 2 {
+		t.Fatalf("segments %+v", infos)
+	}
+	if id, _ := api.AddCategory(Categories{Name: "c"}); id != 10 {
+		t.Fatalf("new category id %d", id)
+	}
+}
@@ -1,6 +1,7 @@
 package coco
 
 /*
+#cgo LDFLAGS: -lm
 #include "../common/maskApi.h"
 #include "../common/maskApi.c"
 #include "stdlib.h"
@@ -11,7 +12,8 @@ import (
 	"unsafe"
 )
 
-//RLE contains a pointer to an array of C.RLE
+//RLE contains a pointer to an array of C.RLE.
+//The C memory is released by a finalizer, prefer the go owned MaskRLE type.
 type RLE struct {
 	r          *C.RLE
 	h, w, size C.siz
@@ -48,6 +50,7 @@ func encodeRLE(mask []byte, h, w, n uint32) *RLE {
 	r.h = C.siz(h)
 	r.w = C.siz(w)
 	C.rleEncode(r.r, (*C.byte)(&mask[0]), (C.siz)(h), (C.siz)(w), (C.siz)(n))
+	runtime.KeepAlive(r)
 	return r
 }
 
@@ -58,6 +61,7 @@ func compressRLE(cnts []uint32, h, w uint32) *RLE {
 	r.h = C.siz(h)
 	r.w = C.siz(w)
     C.rleInit(r.r, (C.siz)(h), (C.siz)(w), (C.siz)(len(cnts)), (*C.uint)(&cnts[0]));
+	runtime.KeepAlive(r)
 	return r
 }
 
@@ -68,6 +72,8 @@ func (r *RLE) Decode() (mask []byte) {
 	// defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
 	mask = make([]byte, r.h*r.w*r.size)
 	C.rleDecode(r.r, (*C.byte)(&mask[0]), r.size)
+	// r must not be finalized while C still reads from it
+	runtime.KeepAlive(r)
 	return mask
 }
 
@@ -80,6 +86,24 @@ func (r *RLE) MergeFrom(m *RLE, intersect bool) {
 		inter = 255
 	}
 	C.rleMerge(m.r, r.r, r.size, (inter))
+	runtime.KeepAlive(m)
+	runtime.KeepAlive(r)
+}
+
+//Merge returns a new RLE holding the union or intersection of all masks of r
+func (r *RLE) Merge(intersect bool) *RLE {
+	out := InitRLEs(1)
+	if r.size == 0 {
+		return out
+	}
+	var inter C.int
+	if intersect {
+		inter = 255
+	}
+	C.rleMerge(r.r, out.r, r.size, inter)
+	out.h, out.w = r.h, r.w
+	runtime.KeepAlive(r)
+	return out
 }
 
 //AreaRLE -  Compute area of encoded masks.
@@ -88,15 +112,54 @@ func (r *RLE) AreaRLE() []uint32 {
 
 	x := make([]uint32, r.size)
 	C.rleArea(r.r, r.size, (*C.uint)(&x[0]))
+	runtime.KeepAlive(r)
 
 	return x
 }
 
+//counts copies the counts of the i-th RLE into go memory
+func (r *RLE) counts(i int) []uint32 {
+	rs := unsafe.Slice(r.r, r.size)
+	cnts := unsafe.Slice((*uint32)(unsafe.Pointer(rs[i].cnts)), rs[i].m)
+	out := append([]uint32(nil), cnts...)
+	runtime.KeepAlive(r)
+	return out
+}
+
+//MaskRLE copies the i-th C RLE into a go owned MaskRLE
+func (r *RLE) MaskRLE(i int) MaskRLE {
+	rs := unsafe.Slice(r.r, r.size)
+	return MaskRLE{
+		Size:   [2]uint32{uint32(rs[i].h), uint32(rs[i].w)},
+		Counts: r.counts(i),
+	}
+}
+
+//ToRLE copies go owned MaskRLEs into an array of C RLEs
+func ToRLE(rles []MaskRLE) *RLE {
+	r := InitRLEs(uint32(len(rles)))
+	rs := unsafe.Slice(r.r, r.size)
+	for i := range rles {
+		var cnts *C.uint
+		if len(rles[i].Counts) > 0 {
+			cnts = (*C.uint)(&rles[i].Counts[0])
+		}
+		C.rleInit(&rs[i], (C.siz)(rles[i].Size[0]), (C.siz)(rles[i].Size[1]), (C.siz)(len(rles[i].Counts)), cnts)
+	}
+	if len(rles) > 0 {
+		r.h = C.siz(rles[0].Size[0])
+		r.w = C.siz(rles[0].Size[1])
+	}
+	return r
+}
+
 //IoURLE Compute intersection over union between masks.
 //void rleIou( RLE *dt, RLE *gt, siz m, siz n, byte *iscrowd, double *o );
 func IoURLE(dt, gt *RLE, iscrowd []byte) (out []float64) {
 	out = make([]float64, gt.size*dt.size)
 	C.rleIou(dt.r, gt.r, dt.size, gt.size, (*C.byte)(&iscrowd[0]), (*C.double)(&out[0]))
+	runtime.KeepAlive(dt)
+	runtime.KeepAlive(gt)
 	return out
 }
 
@@ -107,6 +170,7 @@ func (r *RLE) NonMaxSup(thresh float64) (keep []bool) {
 	keep = make([]bool, r.size)
 	kp := make([]C.uint, r.size)
 	C.rleNms(r.r, r.size, &kp[0], (C.double)(thresh))
+	runtime.KeepAlive(r)
 	for i := range keep {
 		if kp[i] > 0 {
 			keep[i] = true
@@ -117,20 +181,33 @@ func (r *RLE) NonMaxSup(thresh float64) (keep []bool) {
 }
 
 //IoUBB -Compute intersection over union between bounding boxes.
+//dt and gt hold 4 values [x, y, w, h] per box.
 //void bbIou( BB dt, BB gt, siz m, siz n, byte *iscrowd, double *o );
 func IoUBB(dt, gt BB, iscrowd []byte) (out []float64) {
-	out = make([]float64, dt.siz()*gt.siz())
-	C.bbIou(dt.c(), gt.c(), dt.siz(), gt.siz(), (*C.byte)(&iscrowd[0]), (*C.double)(&out[0]))
+	m, n := dt.siz()/4, gt.siz()/4
+	out = make([]float64, m*n)
+	if m == 0 || n == 0 {
+		return out
+	}
+	var crowd *C.byte
+	if len(iscrowd) > 0 {
+		crowd = (*C.byte)(&iscrowd[0])
+	}
+	C.bbIou(dt.c(), gt.c(), m, n, crowd, (*C.double)(&out[0]))
 	return out
 }
 
 //NonMaxSupBB non-maximum suppression between bounding boxes
+//dt holds 4 values [x, y, w, h] per box, sorted by descending score.
 //void bbNms( BB dt, siz n, uint *keep, double thr );
 func NonMaxSupBB(dt BB, thresh float64) (keep []bool) {
-	keep = make([]bool, dt.siz())
-	kp := make([]C.uint, dt.siz())
-	var x C.BB
-	C.bbNms(x, dt.siz(), &kp[0], (C.double)(thresh))
+	n := dt.siz() / 4
+	keep = make([]bool, n)
+	if n == 0 {
+		return keep
+	}
+	kp := make([]C.uint, n)
+	C.bbNms(dt.c(), n, &kp[0], (C.double)(thresh))
 	for i := range keep {
 		if kp[i] > 0 {
 			keep[i] = true
@@ -143,11 +220,10 @@ func NonMaxSupBB(dt BB, thresh float64) (keep []bool) {
 //void rleToBbox( const RLE *R, BB bb, siz n );
 func (r *RLE) ToBB() (bb BB) {
 
-	vol := r.size * r.w * r.h
-
-	bb = make(BB, vol)
+	bb = make(BB, 4*r.size)
 
 	C.rleToBbox(r.r, bb.c(), r.size)
+	runtime.KeepAlive(r)
 	return bb
 }
 
@@ -158,6 +234,7 @@ func (b BB) ToRLE(h, w, n uint32) *RLE {
 	r.h = (C.siz)(h)
 	r.w = (C.siz)(w)
 	C.rleFrBbox(r.r, b.c(), r.h, r.w, r.size)
+	runtime.KeepAlive(r)
 	return r
 }
 
@@ -168,6 +245,7 @@ func RLEFromPoly(poly *float64, k, h, w uint32) *RLE {
 	r.h = (C.siz)(h)
 	r.w = (C.siz)(w)
 	C.rleFrPoly(r.r, (*C.double)(poly), (C.siz)(k), (C.siz)(h), (C.siz)(w))
+	runtime.KeepAlive(r)
 	return r
 
 }
@@ -182,6 +260,7 @@ type Char struct {
 func (r *RLE) ToChar() *Char {
 	x := new(Char)
 	x.Cc = unsafe.Pointer(C.rleToString(r.r))
+	runtime.KeepAlive(r)
 	runtime.SetFinalizer(x, freechar)
 	return x
 }
@@ -190,6 +269,15 @@ func freechar(c *Char) {
 	c = nil
 }
 
+//cCountsFromString decodes a counts string with rleFrStringWithByteLen,
+//the string is copied into C memory first
+func cCountsFromString(s string, h, w uint32) []uint32 {
+	cs := C.CString(s)
+	defer C.free(unsafe.Pointer(cs))
+	c := &Char{Cc: unsafe.Pointer(cs)}
+	return c.ToRLEWithByteLen(h, w, uint32(len(s))).MaskRLE(0).Counts
+}
+
 //ToRLE Convert from compressed string representation of encoded mask.
 //void rleFrString( RLE *R, char *s, siz h, siz w );
 func (c *Char) ToRLE(h, w uint32) *RLE {
@@ -198,6 +286,8 @@ func (c *Char) ToRLE(h, w uint32) *RLE {
 	r.w = (C.siz)(w)
 	C.rleFrString(r.r, (*C.char)(c.Cc), (C.siz)(h), (C.siz)(w))
 	// runtime.SetFinalizer(c, freechar)
+	runtime.KeepAlive(r)
+	runtime.KeepAlive(c)
 	return r
 }
 
@@ -209,5 +299,7 @@ func (c *Char) ToRLEWithByteLen(h, w, bl uint32) *RLE {
 	r.w = (C.siz)(w)
 	C.rleFrStringWithByteLen(r.r, (*C.char)(c.Cc), (C.siz)(h), (C.siz)(w), (C.siz)(bl))
 	// runtime.SetFinalizer(c, freechar)
+	runtime.KeepAlive(r)
+	runtime.KeepAlive(c)
 	return r
 }
@@ -0,0 +1,143 @@
+package coco
+
+import (
+	"math/bits"
+)
+
+//Bitmask is a bit-packed binary mask. Pixel i in column-major order, the
+//order of RLE, is bit i%64 of Bits[i/64]. Set operations work on whole
+//words which is faster than RLE for small images and fragmented masks.
+type Bitmask struct {
+	Size [2]uint32
+	Bits []uint64
+}
+
+//NewBitmask returns an empty mask of size [h, w]
+func NewBitmask(size [2]uint32) Bitmask {
+	n := (uint64(size[0])*uint64(size[1]) + 63) / 64
+	return Bitmask{Size: size, Bits: make([]uint64, n)}
+}
+
+// setRange sets the bits [a, b).
+func (m Bitmask) setRange(a, b uint64) {
+	for a < b {
+		w, off := a/64, a%64
+		n := 64 - off
+		if b-a < n {
+			n = b - a
+		}
+		if n == 64 {
+			m.Bits[w] = ^uint64(0)
+		} else {
+			m.Bits[w] |= ((uint64(1) << n) - 1) << off
+		}
+		a += n
+	}
+}
+
+//Bitmask converts r into a bit-packed mask
+func (r MaskRLE) Bitmask() Bitmask {
+	m := NewBitmask(r.Size)
+	total := uint64(r.Size[0]) * uint64(r.Size[1])
+	pos := uint64(0)
+	for i, c := range r.Counts {
+		end := pos + uint64(c)
+		if end > total {
+			end = total
+		}
+		if i%2 == 1 {
+			m.setRange(pos, end)
+		}
+		pos = end
+	}
+	return m
+}
+
+//MaskRLE converts m into a MaskRLE
+func (m Bitmask) MaskRLE() MaskRLE {
+	total := uint64(m.Size[0]) * uint64(m.Size[1])
+	r := MaskRLE{Size: m.Size}
+	pos, last := uint64(0), uint64(0)
+	// flip is 0 inside a run of zeros and all ones inside a run of ones, so
+	// the next bit of w is always the end of the current run
+	var flip uint64
+	for pos < total {
+		w := (m.Bits[pos/64] ^ flip) >> (pos % 64)
+		if w == 0 {
+			pos += 64 - pos%64
+			continue
+		}
+		pos += uint64(bits.TrailingZeros64(w))
+		if pos >= total {
+			break
+		}
+		r.Counts = append(r.Counts, uint32(pos-last))
+		last = pos
+		flip = ^flip
+	}
+	r.Counts = append(r.Counts, uint32(total-last))
+	return r
+}
+
+func (m Bitmask) combine(o Bitmask, op func(a, b uint64) uint64) Bitmask {
+	out := Bitmask{Size: m.Size, Bits: make([]uint64, len(m.Bits))}
+	for i := range m.Bits {
+		var b uint64
+		if i < len(o.Bits) {
+			b = o.Bits[i]
+		}
+		out.Bits[i] = op(m.Bits[i], b)
+	}
+	return out
+}
+
+//And returns the intersection of m and o
+func (m Bitmask) And(o Bitmask) Bitmask {
+	return m.combine(o, func(a, b uint64) uint64 { return a & b })
+}
+
+//Or returns the union of m and o
+func (m Bitmask) Or(o Bitmask) Bitmask {
+	return m.combine(o, func(a, b uint64) uint64 { return a | b })
+}
+
+//Xor returns the pixels set in exactly one of m and o
+func (m Bitmask) Xor(o Bitmask) Bitmask {
+	return m.combine(o, func(a, b uint64) uint64 { return a ^ b })
+}
+
+//AndNot returns the pixels of m that are not set in o
+func (m Bitmask) AndNot(o Bitmask) Bitmask {
+	return m.combine(o, func(a, b uint64) uint64 { return a &^ b })
+}
+
+//Area Compute area of the mask.
+func (m Bitmask) Area() uint32 {
+	a := 0
+	for _, w := range m.Bits {
+		a += bits.OnesCount64(w)
+	}
+	return uint32(a)
+}
+
+//IoU Compute intersection over union between m as detection and gt with
+//the semantics of MaskRLE.IoU
+func (m Bitmask) IoU(gt Bitmask, iscrowd bool) float64 {
+	if m.Size != gt.Size {
+		return -1
+	}
+	i, u, a := 0, 0, 0
+	for k, w := range m.Bits {
+		g := gt.Bits[k]
+		i += bits.OnesCount64(w & g)
+		u += bits.OnesCount64(w | g)
+		a += bits.OnesCount64(w)
+	}
+	if i == 0 {
+		return 0
+	}
+	if iscrowd {
+		u = a
+	}
+	return float64(i) / float64(u)
+}
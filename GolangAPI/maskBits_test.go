@@ -0,0 +1,154 @@
+package coco
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func Test_Bitmask(t *testing.T) {
+	rnd := rand.New(rand.NewSource(17))
+	for _, hw := range [][2]int{{1, 1}, {8, 8}, {13, 5}, {64, 3}, {37, 29}} {
+		h, w := hw[0], hw[1]
+		size := [2]uint32{uint32(h), uint32(w)}
+		rles := randomRLEs(rnd, 6, h, w)
+		var empty, full MaskRLE
+		empty.Encode(make([]byte, h*w), size)
+		ones := make([]byte, h*w)
+		for i := range ones {
+			ones[i] = 1
+		}
+		full.Encode(ones, size)
+		rles = append(rles, empty, full)
+
+		for i, a := range rles {
+			ba := a.Bitmask()
+			if rt := ba.MaskRLE(); !equalUint32(rt.Counts, a.Counts) || rt.Size != a.Size {
+				t.Fatalf("%dx%d round trip %d: %v != %v", h, w, i, rt.Counts, a.Counts)
+			}
+			if ba.Area() != a.Area() {
+				t.Fatalf("%dx%d area %d: %d != %d", h, w, i, ba.Area(), a.Area())
+			}
+			for j, b := range rles {
+				bb := b.Bitmask()
+				if got, want := ba.IoU(bb, j%2 == 1), a.IoU(b, j%2 == 1); got != want {
+					t.Fatalf("%dx%d iou %d,%d: %v != %v", h, w, i, j, got, want)
+				}
+				ma, mb := a.Decode(), b.Decode()
+				ops := []struct {
+					name string
+					got  Bitmask
+					fn   func(x, y byte) byte
+				}{
+					{"and", ba.And(bb), func(x, y byte) byte { return x & y }},
+					{"or", ba.Or(bb), func(x, y byte) byte { return x | y }},
+					{"xor", ba.Xor(bb), func(x, y byte) byte { return x ^ y }},
+					{"andnot", ba.AndNot(bb), func(x, y byte) byte { return x &^ y }},
+				}
+				for _, op := range ops {
+					mask := make([]byte, h*w)
+					for k := range mask {
+						mask[k] = op.fn(ma[k], mb[k])
+					}
+					var want MaskRLE
+					want.Encode(mask, size)
+					if got := op.got.MaskRLE(); !equalUint32(got.Counts, want.Counts) {
+						t.Fatalf("%dx%d %s %d,%d: %v != %v", h, w, op.name, i, j, got.Counts, want.Counts)
+					}
+				}
+			}
+		}
+	}
+}
+
+// benchmarkMasks returns masks of a 640x480 image, fragmented masks flip
+// every pixel with probability noise.
+func benchmarkMasks(n int, noise float64) []MaskRLE {
+	rnd := rand.New(rand.NewSource(1))
+	h, w := 480, 640
+	rles := make([]MaskRLE, n)
+	for i := range rles {
+		mask := make([]byte, h*w)
+		x0, y0 := rnd.Intn(w/2), rnd.Intn(h/2)
+		for x := x0; x < x0+w/2; x++ {
+			for y := y0; y < y0+h/2; y++ {
+				mask[x*h+y] = 1
+			}
+		}
+		for k := range mask {
+			if rnd.Float64() < noise {
+				mask[k] ^= 1
+			}
+		}
+		rles[i].Encode(mask, [2]uint32{uint32(h), uint32(w)})
+	}
+	return rles
+}
+
+var benchmarkNoise = []struct {
+	name  string
+	noise float64
+}{{"compact", 0}, {"noise1%", 0.01}, {"noise10%", 0.1}}
+
+func BenchmarkIoU(b *testing.B) {
+	for _, bn := range benchmarkNoise {
+		rles := benchmarkMasks(16, bn.noise)
+		iscrowd := make([]byte, len(rles))
+		crles := ToRLE(rles)
+		bits := make([]Bitmask, len(rles))
+		for i := range rles {
+			bits[i] = rles[i].Bitmask()
+		}
+		b.Run(bn.name+"/rleIou", func(b *testing.B) {
+			for k := 0; k < b.N; k++ {
+				IoURLE(crles, crles, iscrowd)
+			}
+		})
+		b.Run(bn.name+"/RLE", func(b *testing.B) {
+			for k := 0; k < b.N; k++ {
+				for _, g := range rles {
+					for _, d := range rles {
+						d.IoU(g, false)
+					}
+				}
+			}
+		})
+		b.Run(bn.name+"/Bitmask", func(b *testing.B) {
+			for k := 0; k < b.N; k++ {
+				for _, g := range bits {
+					for _, d := range bits {
+						d.IoU(g, false)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	for _, bn := range benchmarkNoise {
+		rles := benchmarkMasks(16, bn.noise)
+		crles := ToRLE(rles)
+		bits := make([]Bitmask, len(rles))
+		for i := range rles {
+			bits[i] = rles[i].Bitmask()
+		}
+		b.Run(bn.name+"/rleMerge", func(b *testing.B) {
+			for k := 0; k < b.N; k++ {
+				crles.Merge(false)
+			}
+		})
+		b.Run(bn.name+"/RLE", func(b *testing.B) {
+			for k := 0; k < b.N; k++ {
+				MergeRLEs(rles, false)
+			}
+		})
+		b.Run(bn.name+"/Bitmask", func(b *testing.B) {
+			for k := 0; k < b.N; k++ {
+				out := bits[0]
+				for _, m := range bits[1:] {
+					out = out.Or(m)
+				}
+			}
+		})
+	}
+}
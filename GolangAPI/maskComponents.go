@@ -0,0 +1,344 @@
+package coco
+
+import (
+	"sort"
+)
+
+// Connected components of run-length encoded masks.
+//  ConnectedComponents    - Split a mask into one RLE per connected component.
+//  FillHoles              - Fill background regions that do not touch the image border.
+//  RemoveSmallComponents  - Drop components whose area is below a threshold.
+//  CleanupSegmentations   - Apply the above to every annotation of a CocoApi.
+// Components are labeled on the per-column foreground spans, two spans of
+// neighbouring columns are connected when they overlap (4-connectivity) or
+// touch diagonally (8-connectivity).
+
+//Connectivity is the pixel neighbourhood used for component labeling
+type Connectivity int
+
+const (
+	//Connectivity4 connects pixels sharing an edge
+	Connectivity4 Connectivity = 4
+	//Connectivity8 connects pixels sharing an edge or a corner
+	Connectivity8 Connectivity = 8
+)
+
+//MaskComponent is one connected component of a mask
+type MaskComponent struct {
+	Segmentation *SegmentationRLE
+	Area         float32
+	Bbox         [4]float32
+}
+
+type unionFind []int
+
+func (u unionFind) find(i int) int {
+	for u[i] != i {
+		u[i] = u[u[i]]
+		i = u[i]
+	}
+	return i
+}
+
+func (u unionFind) union(a, b int) {
+	a, b = u.find(a), u.find(b)
+	if a < b {
+		u[b] = a
+	} else if b < a {
+		u[a] = b
+	}
+}
+
+// labelColumns assigns a component label to every span. Labels are numbered
+// in column-major order of first appearance.
+func labelColumns(cols [][]span, conn Connectivity) (labels [][]int, n int) {
+	labels = make([][]int, len(cols))
+	first := make([]int, len(cols)+1)
+	for x := range cols {
+		first[x+1] = first[x] + len(cols[x])
+	}
+	uf := make(unionFind, first[len(cols)])
+	for i := range uf {
+		uf[i] = i
+	}
+	touch := uint32(0)
+	if conn == Connectivity8 {
+		touch = 1
+	}
+	for x := 1; x < len(cols); x++ {
+		a, b := cols[x-1], cols[x]
+		for i, j := 0, 0; i < len(a) && j < len(b); {
+			if a[i].start < b[j].end+touch && b[j].start < a[i].end+touch {
+				uf.union(first[x-1]+i, first[x]+j)
+			}
+			if a[i].end < b[j].end {
+				i++
+			} else {
+				j++
+			}
+		}
+	}
+	ids := make(map[int]int)
+	for x := range cols {
+		labels[x] = make([]int, len(cols[x]))
+		for i := range cols[x] {
+			root := uf.find(first[x] + i)
+			id, ok := ids[root]
+			if !ok {
+				id = len(ids)
+				ids[root] = id
+			}
+			labels[x][i] = id
+		}
+	}
+	return labels, len(ids)
+}
+
+// splitColumns returns one column list per component label.
+func splitColumns(cols [][]span, labels [][]int, n int) [][][]span {
+	parts := make([][][]span, n)
+	for i := range parts {
+		parts[i] = make([][]span, len(cols))
+	}
+	for x := range cols {
+		for i, s := range cols[x] {
+			l := labels[x][i]
+			parts[l][x] = append(parts[l][x], s)
+		}
+	}
+	return parts
+}
+
+func columnsArea(cols [][]span) uint32 {
+	a := uint32(0)
+	for _, c := range cols {
+		for _, s := range c {
+			a += s.end - s.start
+		}
+	}
+	return a
+}
+
+func columnsBbox(cols [][]span) [4]float32 {
+	xs, xe := -1, -1
+	ys, ye := uint32(0), uint32(0)
+	for x, c := range cols {
+		if len(c) == 0 {
+			continue
+		}
+		if xs < 0 {
+			xs = x
+			ys, ye = c[0].start, c[len(c)-1].end
+		}
+		xe = x
+		if c[0].start < ys {
+			ys = c[0].start
+		}
+		if c[len(c)-1].end > ye {
+			ye = c[len(c)-1].end
+		}
+	}
+	if xs < 0 {
+		return [4]float32{}
+	}
+	return [4]float32{float32(xs), float32(ys), float32(xe - xs + 1), float32(ye - ys)}
+}
+
+// complementColumns returns the background spans of every column.
+func complementColumns(cols [][]span, h uint32) [][]span {
+	out := make([][]span, len(cols))
+	for x := range cols {
+		out[x] = subtractSpans([]span{{0, h}}, cols[x])
+	}
+	return out
+}
+
+func fillHolesColumns(cols [][]span, h uint32, conn Connectivity) [][]span {
+	// holes are background components under the dual connectivity
+	bgConn := Connectivity4
+	if conn == Connectivity4 {
+		bgConn = Connectivity8
+	}
+	bg := complementColumns(cols, h)
+	labels, n := labelColumns(bg, bgConn)
+	border := make([]bool, n)
+	for x := range bg {
+		for i, s := range bg[x] {
+			if x == 0 || x == len(bg)-1 || s.start == 0 || s.end == h {
+				border[labels[x][i]] = true
+			}
+		}
+	}
+	out := make([][]span, len(cols))
+	for x := range cols {
+		acc := append([]span(nil), cols[x]...)
+		for i, s := range bg[x] {
+			if !border[labels[x][i]] {
+				acc = append(acc, s)
+			}
+		}
+		out[x] = unionSpans(acc)
+	}
+	return out
+}
+
+func removeSmallColumns(cols [][]span, minArea uint32, conn Connectivity) [][]span {
+	labels, n := labelColumns(cols, conn)
+	areas := make([]uint32, n)
+	for x := range cols {
+		for i, s := range cols[x] {
+			areas[labels[x][i]] += s.end - s.start
+		}
+	}
+	out := make([][]span, len(cols))
+	for x := range cols {
+		for i, s := range cols[x] {
+			if areas[labels[x][i]] >= minArea {
+				out[x] = append(out[x], s)
+			}
+		}
+	}
+	return out
+}
+
+//ConnectedComponents splits a RLE or RLEUncompressed segmentation into its
+//connected components, ordered by their first pixel in column-major order
+func ConnectedComponents(segmentation SegmentationHelper, conn Connectivity) ([]MaskComponent, error) {
+	cnts, size, err := segmentCounts(segmentation)
+	if err != nil {
+		return nil, err
+	}
+	cols := countsToColumns(cnts, size[0], size[1])
+	labels, n := labelColumns(cols, conn)
+	var comps []MaskComponent
+	for _, part := range splitColumns(cols, labels, n) {
+		comps = append(comps, MaskComponent{
+			Segmentation: &SegmentationRLE{
+				Counts: countsToString(columnsToCounts(part, size[0], size[1])),
+				Size:   size,
+			},
+			Area: float32(columnsArea(part)),
+			Bbox: columnsBbox(part),
+		})
+	}
+	return comps, nil
+}
+
+//FillHoles fills every background region that is enclosed by the mask
+func FillHoles(segmentation SegmentationHelper, conn Connectivity) (*SegmentationRLE, error) {
+	return morphSegment(segmentation, func(cnts []uint32, h, w uint32) []uint32 {
+		return columnsToCounts(fillHolesColumns(countsToColumns(cnts, h, w), h, conn), h, w)
+	})
+}
+
+//RemoveSmallComponents removes every connected component whose area is below minArea
+func RemoveSmallComponents(segmentation SegmentationHelper, minArea uint32, conn Connectivity) (*SegmentationRLE, error) {
+	return morphSegment(segmentation, func(cnts []uint32, h, w uint32) []uint32 {
+		return columnsToCounts(removeSmallColumns(countsToColumns(cnts, h, w), minArea, conn), h, w)
+	})
+}
+
+//CleanupOptions controls CleanupSegmentations
+type CleanupOptions struct {
+	Connectivity Connectivity
+	// components with less pixels are removed, 0 keeps all of them
+	MinArea uint32
+	FillHoles bool
+	// rasterize polygon segmentations at the image size and clean them too,
+	// cleaned polygons are rewritten as RLE
+	Polygons bool
+}
+
+//CleanupReport lists the annotations touched by CleanupSegmentations
+type CleanupReport struct {
+	// annotations whose segmentation was rewritten
+	Changed []int
+	// annotations whose mask is empty after the cleanup
+	Empty []int
+}
+
+//CleanupSegmentations removes small components and fills holes of every
+//annotation segmentation, Segmentation, Area and Bbox are rewritten together
+func (api *CocoApi) CleanupSegmentations(opts CleanupOptions) (report CleanupReport, err error) {
+	conn := opts.Connectivity
+	if conn != Connectivity4 {
+		conn = Connectivity8
+	}
+	// the boxes change, also when an error stops the cleanup
+	defer func() {
+		api.indexMu.Lock()
+		api.boxIndexes = make(map[int]*BoxIndex)
+		api.indexMu.Unlock()
+	}()
+	anns := api.datasetMeta.Annotations
+	for i := 0; i < len(anns); i++ {
+		seg := anns[i].Segmentation.SegmentationHelper
+		if seg == nil {
+			continue
+		}
+		var cnts []uint32
+		var size [2]uint32
+		if _, ok := seg.(*SegmentationPolygon); ok {
+			if !opts.Polygons {
+				continue
+			}
+			img, ok := api.imgMap[anns[i].ImageID]
+			if !ok {
+				continue
+			}
+			size = [2]uint32{uint32(img.Height), uint32(img.Width)}
+			cnts, err = rasterizeSegment(seg, size[0], size[1])
+		} else {
+			cnts, size, err = segmentCounts(seg)
+		}
+		if err != nil {
+			return
+		}
+
+		cols := countsToColumns(cnts, size[0], size[1])
+		if opts.MinArea > 0 {
+			cols = removeSmallColumns(cols, opts.MinArea, conn)
+		}
+		if opts.FillHoles {
+			cols = fillHolesColumns(cols, size[0], conn)
+		}
+		cleaned := columnsToCounts(cols, size[0], size[1])
+
+		if !equalCounts(cleaned, cnts) {
+			api.segCache.forget(seg)
+			anns[i].Segmentation = Segment{&SegmentationRLE{
+				Counts: countsToString(cleaned),
+				Size:   size,
+			}}
+			report.Changed = append(report.Changed, anns[i].ID)
+		}
+		anns[i].Area = float32(columnsArea(cols))
+		anns[i].Bbox = columnsBbox(cols)
+		if anns[i].Area == 0 {
+			report.Empty = append(report.Empty, anns[i].ID)
+		}
+		api.annMap[anns[i].ID] = anns[i]
+	}
+	sort.Ints(report.Changed)
+	sort.Ints(report.Empty)
+	return
+}
+
+// equalCounts compares two encodings, ignoring empty runs at the end.
+func equalCounts(a, b []uint32) bool {
+	for len(a) > 1 && a[len(a)-1] == 0 {
+		a = a[:len(a)-1]
+	}
+	for len(b) > 1 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+package coco
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func bruteComponents(mask []byte, h, w int, conn Connectivity) []int {
+	label := make([]int, h*w)
+	var areas []int
+	for start := range mask {
+		if mask[start] == 0 || label[start] != 0 {
+			continue
+		}
+		areas = append(areas, 0)
+		id := len(areas)
+		stack := []int{start}
+		label[start] = id
+		for len(stack) > 0 {
+			p := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			areas[id-1]++
+			x, y := p/h, p%h
+			for dx := -1; dx <= 1; dx++ {
+				for dy := -1; dy <= 1; dy++ {
+					if (dx == 0 && dy == 0) || (conn == Connectivity4 && dx != 0 && dy != 0) {
+						continue
+					}
+					sx, sy := x+dx, y+dy
+					if sx < 0 || sx >= w || sy < 0 || sy >= h {
+						continue
+					}
+					q := sx*h + sy
+					if mask[q] == 1 && label[q] == 0 {
+						label[q] = id
+						stack = append(stack, q)
+					}
+				}
+			}
+		}
+	}
+	return areas
+}
+
+func Test_ConnectedComponents(t *testing.T) {
+	rnd := rand.New(rand.NewSource(11))
+	for iter := 0; iter < 50; iter++ {
+		h, w := 5+rnd.Intn(25), 5+rnd.Intn(25)
+		mask := randomMask(rnd, h, w)
+		seg := &SegmentationRLEUncompressed{Counts: maskToCounts(mask), Size: [2]uint32{uint32(h), uint32(w)}}
+		for _, conn := range []Connectivity{Connectivity4, Connectivity8} {
+			comps, err := ConnectedComponents(seg, conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := bruteComponents(mask, h, w, conn)
+			if len(comps) != len(want) {
+				t.Fatalf("conn %d: got %d components, want %d", conn, len(comps), len(want))
+			}
+			for i := range comps {
+				if int(comps[i].Area) != want[i] {
+					t.Fatalf("conn %d: component %d area %v, want %d", conn, i, comps[i].Area, want[i])
+				}
+			}
+		}
+	}
+}
+
+func Test_ConnectedComponentsZeroRun(t *testing.T) {
+	// the ones at rows 1-2 and row 3 of column 0 are split by an empty run
+	seg := &SegmentationRLEUncompressed{Counts: []uint32{1, 2, 0, 1, 4}, Size: [2]uint32{4, 2}}
+	comps, err := ConnectedComponents(seg, Connectivity4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comps) != 1 || comps[0].Area != 3 || comps[0].Bbox != [4]float32{0, 1, 1, 3} {
+		t.Fatalf("unexpected components: %+v", comps)
+	}
+}
+
+func Test_FillHoles(t *testing.T) {
+	h, w := 7, 7
+	mask := make([]byte, h*w)
+	for x := 1; x < 6; x++ {
+		for y := 1; y < 6; y++ {
+			if x == 1 || x == 5 || y == 1 || y == 5 {
+				mask[x*h+y] = 1
+			}
+		}
+	}
+	seg := &SegmentationRLEUncompressed{Counts: maskToCounts(mask), Size: [2]uint32{7, 7}}
+	filled, err := FillHoles(seg, Connectivity8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comps, _ := ConnectedComponents(filled, Connectivity8)
+	if len(comps) != 1 || comps[0].Area != 25 || comps[0].Bbox != [4]float32{1, 1, 5, 5} {
+		t.Fatalf("unexpected filled mask: %+v", comps)
+	}
+	cleaned, _ := RemoveSmallComponents(filled, 26, Connectivity8)
+	if comps, _ := ConnectedComponents(cleaned, Connectivity8); len(comps) != 0 {
+		t.Fatalf("small component was not removed")
+	}
+}
+
+func Test_CleanupSegmentations(t *testing.T) {
+	api, err := NewCocoApi(datasetMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// build the box indexes before the boxes change
+	before := make(map[int][4]float32)
+	segs := make(map[int]SegmentationHelper)
+	for _, id := range api.GetImgIds(nil) {
+		api.BoxIndex(id)
+		for _, ann := range api.LoadAnns(api.imgToAnnMap[id]) {
+			before[ann.ID] = ann.Bbox
+			segs[ann.ID] = ann.Segmentation.SegmentationHelper
+		}
+	}
+	report, err := api.CleanupSegmentations(CleanupOptions{MinArea: 20, FillHoles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ann := range api.LoadAnns(report.Changed) {
+		comps, _ := ConnectedComponents(ann.Segmentation.SegmentationHelper, Connectivity8)
+		area := float32(0)
+		for _, c := range comps {
+			if c.Area < 20 {
+				t.Fatalf("annotation %d still has a component of area %v", ann.ID, c.Area)
+			}
+			area += c.Area
+		}
+		if area != ann.Area {
+			t.Fatalf("annotation %d area %v, segmentation area %v", ann.ID, ann.Area, area)
+		}
+	}
+	for _, ann := range api.LoadAnns(report.Changed) {
+		b := before[ann.ID]
+		x, y := b[0]+b[2]/2, b[1]+b[3]/2
+		want := NewBoxIndex(api.LoadAnns(api.imgToAnnMap[ann.ImageID])).Covering(x, y)
+		if got := api.BoxIndex(ann.ImageID).Covering(x, y); !equalInts(got, want) {
+			t.Fatalf("box index of image %d is stale: %v, want %v", ann.ImageID, got, want)
+		}
+	}
+	var changed, empty []int
+	for _, ann := range api.datasetMeta.Annotations {
+		if seg := ann.Segmentation.SegmentationHelper; seg != segs[ann.ID] {
+			changed = append(changed, ann.ID)
+		}
+		if _, poly := ann.Segmentation.SegmentationHelper.(*SegmentationPolygon); !poly && ann.Area == 0 {
+			empty = append(empty, ann.ID)
+		}
+	}
+	sort.Ints(changed)
+	sort.Ints(empty)
+	if len(changed) == 0 || !equalInts(report.Changed, changed) || !equalInts(report.Empty, empty) {
+		t.Fatalf("report %+v, changed %v empty %v", report, changed, empty)
+	}
+}
@@ -0,0 +1,38 @@
+package coco
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+)
+
+func Test_IoUBatch(t *testing.T) {
+	rnd := rand.New(rand.NewSource(13))
+	dt := randomRLEs(rnd, 70, 30, 20)
+	gt := randomRLEs(rnd, 45, 30, 20)
+	iscrowd := make([]byte, len(gt))
+	for i := range iscrowd {
+		iscrowd[i] = byte(rnd.Intn(2))
+	}
+	want := IoURLE(ToRLE(dt), ToRLE(gt), iscrowd)
+	for _, rep := range []MaskRepresentation{MaskAuto, MaskRuns, MaskBits} {
+		got, err := IoUBatch(context.Background(), dt, gt, iscrowd, IoUOptions{Workers: 4, TileSize: 16, Representation: rep})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("representation %d iou %d: batch %v, IoURLE %v", rep, i, got[i], want[i])
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := IoUBatch(ctx, dt, gt, nil, IoUOptions{TileSize: 1}); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := IoUBatch(context.Background(), dt, gt, iscrowd[:len(gt)-1], IoUOptions{}); err == nil {
+		t.Fatal("expected an error for a short iscrowd")
+	}
+}
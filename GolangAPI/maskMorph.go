@@ -0,0 +1,380 @@
+package coco
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Binary morphology on run-length encoded masks.
+// COCO masks are stored in column-major order, so every mask is handled as
+// a list of foreground runs per column and the structuring element is
+// applied run by run instead of pixel by pixel.
+//  ErodeSegment        - Erode a mask with a structuring element.
+//  DilateSegment       - Dilate a mask with a structuring element.
+//  OpenSegment         - Erode then dilate, removes specks smaller than the element.
+//  CloseSegment        - Dilate then erode, fills gaps smaller than the element.
+//  BoundaryBandSegment - Inner boundary of the given width.
+// Pixels outside of the image do not take part in erosion, so objects that
+// touch the image border are not eroded from that side.
+
+//ElementShape is the footprint of a StructuringElement
+type ElementShape int
+
+const (
+	//ElementSquare covers (2r+1)x(2r+1) pixels
+	ElementSquare ElementShape = iota
+	//ElementDisk covers the pixels within euclidean distance r of the origin
+	ElementDisk
+)
+
+//StructuringElement is a symmetric footprint centered on the origin
+type StructuringElement struct {
+	Shape  ElementShape
+	Radius int
+}
+
+//SquareElement returns a square structuring element of the given radius
+func SquareElement(radius int) StructuringElement {
+	return StructuringElement{Shape: ElementSquare, Radius: radius}
+}
+
+//DiskElement returns a disk structuring element of the given radius
+func DiskElement(radius int) StructuringElement {
+	return StructuringElement{Shape: ElementDisk, Radius: radius}
+}
+
+// extents returns the vertical half-extent of the element for every column
+// offset dx in [-r, r], indexed by dx+r.
+func (se StructuringElement) extents() []int {
+	r := se.Radius
+	if r < 0 {
+		r = 0
+	}
+	ext := make([]int, 2*r+1)
+	for dx := -r; dx <= r; dx++ {
+		switch se.Shape {
+		case ElementDisk:
+			ext[dx+r] = int(math.Floor(math.Sqrt(float64(r*r - dx*dx))))
+		default:
+			ext[dx+r] = r
+		}
+	}
+	return ext
+}
+
+// span is a half-open foreground interval [start, end) of rows in one column.
+type span struct {
+	start, end uint32
+}
+
+// countsToColumns splits uncompressed counts into per-column foreground spans.
+// Runs of ones separated by a zero length run become one span, so the spans
+// of a column never touch and labelColumns sees them as one object.
+func countsToColumns(cnts []uint32, h, w uint32) [][]span {
+	cols := make([][]span, w)
+	if h == 0 || w == 0 {
+		return cols
+	}
+	total := uint64(h) * uint64(w)
+	pos := uint64(0)
+	for i := 0; i < len(cnts) && pos < total; i++ {
+		c := uint64(cnts[i])
+		if pos+c > total {
+			c = total - pos
+		}
+		if i%2 == 1 {
+			for p, left := pos, c; left > 0; {
+				x := p / uint64(h)
+				y := p % uint64(h)
+				n := uint64(h) - y
+				if n > left {
+					n = left
+				}
+				if k := len(cols[x]) - 1; k >= 0 && cols[x][k].end == uint32(y) {
+					// a zero length run of zeros joins two runs of ones
+					cols[x][k].end = uint32(y + n)
+				} else {
+					cols[x] = append(cols[x], span{uint32(y), uint32(y + n)})
+				}
+				p += n
+				left -= n
+			}
+		}
+		pos += c
+	}
+	return cols
+}
+
+// columnsToCounts joins per-column foreground spans back into uncompressed counts.
+func columnsToCounts(cols [][]span, h, w uint32) []uint32 {
+	var cnts []uint32
+	prev := uint64(0)
+	for x := 0; x < len(cols); x++ {
+		base := uint64(x) * uint64(h)
+		for _, s := range cols[x] {
+			a, b := base+uint64(s.start), base+uint64(s.end)
+			if a == prev && len(cnts) > 0 && len(cnts)%2 == 0 {
+				cnts[len(cnts)-1] += uint32(b - a)
+			} else {
+				cnts = append(cnts, uint32(a-prev), uint32(b-a))
+			}
+			prev = b
+		}
+	}
+	total := uint64(h) * uint64(w)
+	if len(cnts) == 0 || prev < total {
+		cnts = append(cnts, uint32(total-prev))
+	}
+	return cnts
+}
+
+// unionSpans merges possibly overlapping spans into a sorted disjoint list.
+func unionSpans(spans []span) []span {
+	if len(spans) < 2 {
+		return spans
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	out := spans[:1]
+	for _, s := range spans[1:] {
+		last := &out[len(out)-1]
+		if s.start <= last.end {
+			if s.end > last.end {
+				last.end = s.end
+			}
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// intersectSpans intersects two sorted disjoint span lists.
+func intersectSpans(a, b []span) []span {
+	var out []span
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		s, e := a[i].start, a[i].end
+		if b[j].start > s {
+			s = b[j].start
+		}
+		if b[j].end < e {
+			e = b[j].end
+		}
+		if s < e {
+			out = append(out, span{s, e})
+		}
+		if a[i].end < b[j].end {
+			i++
+		} else {
+			j++
+		}
+	}
+	return out
+}
+
+// subtractSpans removes b from a, both sorted and disjoint.
+func subtractSpans(a, b []span) []span {
+	var out []span
+	j := 0
+	for _, s := range a {
+		cur := s.start
+		for j < len(b) && b[j].end <= cur {
+			j++
+		}
+		for k := j; k < len(b) && b[k].start < s.end; k++ {
+			if b[k].start > cur {
+				out = append(out, span{cur, b[k].start})
+			}
+			if b[k].end > cur {
+				cur = b[k].end
+			}
+		}
+		if cur < s.end {
+			out = append(out, span{cur, s.end})
+		}
+	}
+	return out
+}
+
+func dilateColumns(cols [][]span, h uint32, se StructuringElement) [][]span {
+	ext := se.extents()
+	r := len(ext) / 2
+	w := len(cols)
+	out := make([][]span, w)
+	for x := 0; x < w; x++ {
+		var acc []span
+		for dx := -r; dx <= r; dx++ {
+			sx := x - dx
+			if sx < 0 || sx >= w {
+				continue
+			}
+			e := uint32(ext[dx+r])
+			for _, s := range cols[sx] {
+				start, end := uint32(0), s.end+e
+				if s.start > e {
+					start = s.start - e
+				}
+				if end > h {
+					end = h
+				}
+				acc = append(acc, span{start, end})
+			}
+		}
+		out[x] = unionSpans(acc)
+	}
+	return out
+}
+
+func erodeColumns(cols [][]span, h uint32, se StructuringElement) [][]span {
+	ext := se.extents()
+	r := len(ext) / 2
+	w := len(cols)
+	out := make([][]span, w)
+	for x := 0; x < w; x++ {
+		acc := []span{{0, h}}
+		for dx := -r; dx <= r && len(acc) > 0; dx++ {
+			sx := x + dx
+			if sx < 0 || sx >= w {
+				continue
+			}
+			e := uint32(ext[dx+r])
+			var shrunk []span
+			for _, s := range cols[sx] {
+				start, end := s.start, s.end
+				if start > 0 {
+					start += e
+				}
+				if end < h {
+					if end < e {
+						end = 0
+					} else {
+						end -= e
+					}
+				}
+				if start < end {
+					shrunk = append(shrunk, span{start, end})
+				}
+			}
+			acc = intersectSpans(acc, shrunk)
+		}
+		out[x] = acc
+	}
+	return out
+}
+
+func rleDilate(cnts []uint32, h, w uint32, se StructuringElement) []uint32 {
+	return columnsToCounts(dilateColumns(countsToColumns(cnts, h, w), h, se), h, w)
+}
+
+func rleErode(cnts []uint32, h, w uint32, se StructuringElement) []uint32 {
+	return columnsToCounts(erodeColumns(countsToColumns(cnts, h, w), h, se), h, w)
+}
+
+func rleOpen(cnts []uint32, h, w uint32, se StructuringElement) []uint32 {
+	cols := countsToColumns(cnts, h, w)
+	return columnsToCounts(dilateColumns(erodeColumns(cols, h, se), h, se), h, w)
+}
+
+func rleClose(cnts []uint32, h, w uint32, se StructuringElement) []uint32 {
+	cols := countsToColumns(cnts, h, w)
+	return columnsToCounts(erodeColumns(dilateColumns(cols, h, se), h, se), h, w)
+}
+
+func rleBoundaryBand(cnts []uint32, h, w uint32, width int, shape ElementShape) []uint32 {
+	cols := countsToColumns(cnts, h, w)
+	inner := erodeColumns(cols, h, StructuringElement{Shape: shape, Radius: width})
+	for x := range cols {
+		cols[x] = subtractSpans(cols[x], inner[x])
+	}
+	return columnsToCounts(cols, h, w)
+}
+
+// segmentCounts returns the uncompressed counts and size of a RLE segmentation.
+// Polygons carry no image size and have to be rasterized by the caller first.
+func segmentCounts(segmentation SegmentationHelper) ([]uint32, [2]uint32, error) {
+	rle, err := NewMaskRLE(segmentation)
+	return rle.Counts, rle.Size, err
+}
+
+// rasterizeSegment returns the uncompressed counts of any segmentation at the
+// given size, polygons are rasterized ring by ring and merged.
+func rasterizeSegment(segmentation SegmentationHelper, h, w uint32) ([]uint32, error) {
+	poly, ok := segmentation.(*SegmentationPolygon)
+	if !ok {
+		cnts, size, err := segmentCounts(segmentation)
+		if err != nil {
+			return nil, err
+		}
+		if size[0] != h || size[1] != w {
+			return nil, errors.New("segmentation size does not match image size")
+		}
+		return cnts, nil
+	}
+	cols := make([][]span, w)
+	for _, ring := range *poly {
+		k := len(ring) / 2
+		if k < 3 {
+			continue
+		}
+		xy := make([]float64, 2*k)
+		for j := range xy {
+			xy[j] = float64(ring[j])
+		}
+		part := countsToColumns(RLEFromPoly(&xy[0], uint32(k), h, w).MaskRLE(0).Counts, h, w)
+		for x := range cols {
+			if len(part[x]) > 0 {
+				cols[x] = unionSpans(append(cols[x], part[x]...))
+			}
+		}
+	}
+	return columnsToCounts(cols, h, w), nil
+}
+
+type morphFunc func(cnts []uint32, h, w uint32) []uint32
+
+func morphSegment(segmentation SegmentationHelper, fn morphFunc) (*SegmentationRLE, error) {
+	cnts, size, err := segmentCounts(segmentation)
+	if err != nil {
+		return nil, err
+	}
+	return &SegmentationRLE{
+		Counts: countsToString(fn(cnts, size[0], size[1])),
+		Size:   size,
+	}, nil
+}
+
+//ErodeSegment erodes a RLE or RLEUncompressed segmentation
+func ErodeSegment(segmentation SegmentationHelper, se StructuringElement) (*SegmentationRLE, error) {
+	return morphSegment(segmentation, func(cnts []uint32, h, w uint32) []uint32 {
+		return rleErode(cnts, h, w, se)
+	})
+}
+
+//DilateSegment dilates a RLE or RLEUncompressed segmentation
+func DilateSegment(segmentation SegmentationHelper, se StructuringElement) (*SegmentationRLE, error) {
+	return morphSegment(segmentation, func(cnts []uint32, h, w uint32) []uint32 {
+		return rleDilate(cnts, h, w, se)
+	})
+}
+
+//OpenSegment erodes then dilates a RLE or RLEUncompressed segmentation
+func OpenSegment(segmentation SegmentationHelper, se StructuringElement) (*SegmentationRLE, error) {
+	return morphSegment(segmentation, func(cnts []uint32, h, w uint32) []uint32 {
+		return rleOpen(cnts, h, w, se)
+	})
+}
+
+//CloseSegment dilates then erodes a RLE or RLEUncompressed segmentation
+func CloseSegment(segmentation SegmentationHelper, se StructuringElement) (*SegmentationRLE, error) {
+	return morphSegment(segmentation, func(cnts []uint32, h, w uint32) []uint32 {
+		return rleClose(cnts, h, w, se)
+	})
+}
+
+//BoundaryBandSegment returns the inner boundary of the given width, i.e. the
+//pixels of the mask that are removed by an erosion with radius width
+func BoundaryBandSegment(segmentation SegmentationHelper, width int, shape ElementShape) (*SegmentationRLE, error) {
+	return morphSegment(segmentation, func(cnts []uint32, h, w uint32) []uint32 {
+		return rleBoundaryBand(cnts, h, w, width, shape)
+	})
+}
@@ -0,0 +1,131 @@
+package coco
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func randomMask(rnd *rand.Rand, h, w int) []byte {
+	mask := make([]byte, h*w)
+	for i := 0; i < 6; i++ {
+		x0, y0 := rnd.Intn(w), rnd.Intn(h)
+		bw, bh := 1+rnd.Intn(w/2+1), 1+rnd.Intn(h/2+1)
+		for x := x0; x < x0+bw && x < w; x++ {
+			for y := y0; y < y0+bh && y < h; y++ {
+				mask[x*h+y] = 1
+			}
+		}
+	}
+	for i := 0; i < h*w/20; i++ {
+		mask[rnd.Intn(h*w)] ^= 1
+	}
+	return mask
+}
+
+func countsToMask(cnts []uint32, h, w int) []byte {
+	mask := make([]byte, h*w)
+	pos := 0
+	for i, c := range cnts {
+		for k := 0; k < int(c); k++ {
+			mask[pos] = byte(i % 2)
+			pos++
+		}
+	}
+	return mask
+}
+
+func maskToCounts(mask []byte) []uint32 {
+	var cnts []uint32
+	var p byte
+	c := uint32(0)
+	for _, v := range mask {
+		if v != p {
+			cnts = append(cnts, c)
+			c = 0
+			p = v
+		}
+		c++
+	}
+	return append(cnts, c)
+}
+
+func bruteMorph(mask []byte, h, w int, se StructuringElement, erode bool) []byte {
+	ext := se.extents()
+	r := len(ext) / 2
+	out := make([]byte, h*w)
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			hit := erode
+			for dx := -r; dx <= r; dx++ {
+				for dy := -ext[dx+r]; dy <= ext[dx+r]; dy++ {
+					sx, sy := x+dx, y+dy
+					if sx < 0 || sx >= w || sy < 0 || sy >= h {
+						continue
+					}
+					v := mask[sx*h+sy] == 1
+					if erode && !v {
+						hit = false
+					}
+					if !erode && v {
+						hit = true
+					}
+				}
+			}
+			if hit {
+				out[x*h+y] = 1
+			}
+		}
+	}
+	return out
+}
+
+func Test_morphology(t *testing.T) {
+	rnd := rand.New(rand.NewSource(7))
+	for iter := 0; iter < 50; iter++ {
+		h, w := 5+rnd.Intn(30), 5+rnd.Intn(30)
+		mask := randomMask(rnd, h, w)
+		cnts := maskToCounts(mask)
+		for _, se := range []StructuringElement{SquareElement(1), SquareElement(2), DiskElement(2), DiskElement(3)} {
+			dil := countsToMask(rleDilate(cnts, uint32(h), uint32(w), se), h, w)
+			ero := countsToMask(rleErode(cnts, uint32(h), uint32(w), se), h, w)
+			wantDil := bruteMorph(mask, h, w, se, false)
+			wantEro := bruteMorph(mask, h, w, se, true)
+			for i := range mask {
+				if dil[i] != wantDil[i] {
+					t.Fatalf("dilate %v mismatch at %d (h=%d w=%d)", se, i, h, w)
+				}
+				if ero[i] != wantEro[i] {
+					t.Fatalf("erode %v mismatch at %d (h=%d w=%d)", se, i, h, w)
+				}
+			}
+			band := countsToMask(rleBoundaryBand(cnts, uint32(h), uint32(w), se.Radius, se.Shape), h, w)
+			for i := range mask {
+				if band[i] != mask[i]&^wantEro[i] {
+					t.Fatalf("boundary band %v mismatch at %d", se, i)
+				}
+			}
+		}
+	}
+}
+
+func Test_morphologySegment(t *testing.T) {
+	size := [2]uint32{5, 6}
+	originMask := []byte{0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 1, 1, 0, 1, 1, 0, 0, 0, 0, 0, 1, 1, 0, 1, 1}
+	seg := EncodeMaskToSegment(originMask, size)
+	if countsToString(countsFromString(seg.Counts)) != seg.Counts {
+		t.Fatalf("counts string round trip failed: %s", seg.Counts)
+	}
+	closed, err := CloseSegment(seg, SquareElement(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mask := DecodeSegmentToMask(closed)
+	for i, v := range originMask {
+		if v == 1 && mask[i] != 1 {
+			t.Fatalf("closing removed pixel %d", i)
+		}
+	}
+	if _, err := ErodeSegment(&SegmentationPolygon{}, SquareElement(1)); err == nil {
+		t.Fatal("expected error for polygon segmentation")
+	}
+}
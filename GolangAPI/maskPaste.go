@@ -0,0 +1,139 @@
+package coco
+
+import (
+	"errors"
+	"math"
+	"runtime"
+	"sync"
+)
+
+//MaskGrid is a low resolution probability mask of a mask head, e.g. 28x28,
+//that covers the box of its detection. Probs is stored row-major.
+type MaskGrid struct {
+	Width, Height int
+	Probs         []float32
+}
+
+// pasteTaps holds the bilinear taps of one image row or column.
+type pasteTaps struct {
+	i0, i1 int
+	w0, w1 float32
+}
+
+// pasteAxis maps image pixels [p0, p1) into a grid of n cells spread over
+// [lo, lo+size) like grid_sample with align_corners=False, taps outside the
+// grid have weight 0.
+func pasteAxis(p0, p1 int, lo, size float64, n int) []pasteTaps {
+	taps := make([]pasteTaps, p1-p0)
+	for p := p0; p < p1; p++ {
+		u := (float64(p)+0.5-lo)/size*float64(n) - 0.5
+		f := math.Floor(u)
+		i0 := int(f)
+		w1 := float32(u - f)
+		t := pasteTaps{i0: i0, i1: i0 + 1, w0: 1 - w1, w1: w1}
+		if t.i0 < 0 || t.i0 >= n {
+			t.i0, t.w0 = 0, 0
+		}
+		if t.i1 < 0 || t.i1 >= n {
+			t.i1, t.w1 = 0, 0
+		}
+		taps[p-p0] = t
+	}
+	return taps
+}
+
+//PasteMask resamples the mask grid of det bilinearly into det.Bbox and
+//keeps the pixels whose probability reaches threshold. The result is a copy
+//of det with a SegmentationRLE of the image size, the Area of the mask and
+//the Bbox surrounding the mask like loadRes computes them for segm results.
+//Only pixels whose center lies inside det.Bbox can be set.
+func PasteMask(img Image, det Annotation, grid MaskGrid, threshold float32) (Annotation, error) {
+	if grid.Width <= 0 || grid.Height <= 0 || len(grid.Probs) != grid.Width*grid.Height {
+		return det, errors.New("mask grid does not match its size")
+	}
+	if img.Width < 0 || img.Height < 0 {
+		return det, errors.New("invalid image size")
+	}
+	h, w := uint32(img.Height), uint32(img.Width)
+	box := det.Bbox
+	cols := make([][]span, w)
+
+	// pixels whose center lies inside the box
+	clip := func(lo, size float32, n int) (int, int) {
+		a := int(math.Ceil(float64(lo) - 0.5))
+		b := int(math.Ceil(float64(lo+size) - 0.5))
+		if a < 0 {
+			a = 0
+		}
+		if b > n {
+			b = n
+		}
+		return a, b
+	}
+	x0, x1 := clip(box[0], box[2], img.Width)
+	y0, y1 := clip(box[1], box[3], img.Height)
+	if box[2] > 0 && box[3] > 0 && x0 < x1 && y0 < y1 {
+		xt := pasteAxis(x0, x1, float64(box[0]), float64(box[2]), grid.Width)
+		yt := pasteAxis(y0, y1, float64(box[1]), float64(box[3]), grid.Height)
+		at := func(gx, gy int) float32 { return grid.Probs[gy*grid.Width+gx] }
+		for x := x0; x < x1; x++ {
+			tx := xt[x-x0]
+			var col []span
+			for y := y0; y < y1; y++ {
+				ty := yt[y-y0]
+				v := ty.w0*(tx.w0*at(tx.i0, ty.i0)+tx.w1*at(tx.i1, ty.i0)) +
+					ty.w1*(tx.w0*at(tx.i0, ty.i1)+tx.w1*at(tx.i1, ty.i1))
+				if v < threshold {
+					continue
+				}
+				if n := len(col); n > 0 && col[n-1].end == uint32(y) {
+					col[n-1].end++
+				} else {
+					col = append(col, span{uint32(y), uint32(y) + 1})
+				}
+			}
+			cols[x] = col
+		}
+	}
+
+	det.ImageID = img.ID
+	det.Segmentation = Segment{&SegmentationRLE{
+		Counts: countsToString(columnsToCounts(cols, h, w)),
+		Size:   [2]uint32{h, w},
+	}}
+	det.Area = float32(columnsArea(cols))
+	det.Bbox = columnsBbox(cols)
+	return det, nil
+}
+
+//PasteMasks pastes the mask grid of every detection of img with PasteMask,
+//the detections are processed on runtime.NumCPU() goroutines
+func PasteMasks(img Image, dets []Annotation, grids []MaskGrid, threshold float32) ([]Annotation, error) {
+	if len(dets) != len(grids) {
+		return nil, errors.New("need one mask grid per detection")
+	}
+	out := make([]Annotation, len(dets))
+	errs := make([]error, len(dets))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for k := 0; k < runtime.NumCPU(); k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				out[i], errs[i] = PasteMask(img, dets[i], grids[i], threshold)
+			}
+		}()
+	}
+	for i := range dets {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,119 @@
+package coco
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// brutePaste evaluates grid_sample for every pixel of the image.
+func brutePaste(h, w int, box [4]float32, grid MaskGrid, threshold float32) []byte {
+	mask := make([]byte, h*w)
+	at := func(gx, gy int) float64 {
+		if gx < 0 || gy < 0 || gx >= grid.Width || gy >= grid.Height {
+			return 0
+		}
+		return float64(grid.Probs[gy*grid.Width+gx])
+	}
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			cx, cy := float64(x)+0.5, float64(y)+0.5
+			if cx < float64(box[0]) || cx >= float64(box[0]+box[2]) || cy < float64(box[1]) || cy >= float64(box[1]+box[3]) {
+				continue
+			}
+			u := (cx-float64(box[0]))/float64(box[2])*float64(grid.Width) - 0.5
+			v := (cy-float64(box[1]))/float64(box[3])*float64(grid.Height) - 0.5
+			ix, iy := int(math.Floor(u)), int(math.Floor(v))
+			fx, fy := u-math.Floor(u), v-math.Floor(v)
+			p := (1-fy)*((1-fx)*at(ix, iy)+fx*at(ix+1, iy)) + fy*((1-fx)*at(ix, iy+1)+fx*at(ix+1, iy+1))
+			if p >= float64(threshold)+1e-5 {
+				mask[x*h+y] = 1
+			} else if p > float64(threshold)-1e-5 {
+				mask[x*h+y] = 2 // too close to call in float32
+			}
+		}
+	}
+	return mask
+}
+
+func randomGrid(rnd *rand.Rand, n int) MaskGrid {
+	g := MaskGrid{Width: n, Height: n, Probs: make([]float32, n*n)}
+	for i := range g.Probs {
+		g.Probs[i] = rnd.Float32()
+	}
+	return g
+}
+
+func Test_PasteMask(t *testing.T) {
+	rnd := rand.New(rand.NewSource(21))
+	img := Image{ID: 7, Width: 61, Height: 47}
+	for it := 0; it < 50; it++ {
+		box := [4]float32{rnd.Float32()*70 - 10, rnd.Float32()*50 - 10, 1 + rnd.Float32()*40, 1 + rnd.Float32()*30}
+		grid := randomGrid(rnd, 3+rnd.Intn(12))
+		r, err := PasteMask(img, Annotation{CategoryID: 3, Score: 0.5, Bbox: box}, grid, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.ImageID != 7 || r.CategoryID != 3 || r.Score != 0.5 {
+			t.Fatalf("case %d: detection fields not kept: %+v", it, r)
+		}
+		rle, err := NewMaskRLE(r.Segmentation.SegmentationHelper)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rle.Size != [2]uint32{47, 61} {
+			t.Fatalf("case %d: size %v", it, rle.Size)
+		}
+		got := rle.Decode()
+		want := brutePaste(img.Height, img.Width, box, grid, 0.5)
+		for i := range want {
+			if want[i] != 2 && got[i] != want[i] {
+				t.Fatalf("case %d: pixel %d is %d, expected %d", it, i, got[i], want[i])
+			}
+		}
+		if r.Area != float32(rle.Area()) || r.Bbox != rle.Bbox() {
+			t.Fatalf("case %d: area %v bbox %v, mask has %v %v", it, r.Area, r.Bbox, rle.Area(), rle.Bbox())
+		}
+	}
+
+	// a certain mask fills its integer box
+	ones := MaskGrid{Width: 28, Height: 28, Probs: make([]float32, 28*28)}
+	for i := range ones.Probs {
+		ones.Probs[i] = 1
+	}
+	r, err := PasteMask(img, Annotation{Bbox: [4]float32{5, 6, 20, 10}}, ones, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Area != 200 || r.Bbox != [4]float32{5, 6, 20, 10} {
+		t.Fatalf("full mask area %v bbox %v", r.Area, r.Bbox)
+	}
+
+	if _, err := PasteMask(img, Annotation{}, MaskGrid{Width: 2, Height: 2}, 0.5); err == nil {
+		t.Fatal("expected an error for a grid without probabilities")
+	}
+}
+
+func Test_PasteMasks(t *testing.T) {
+	rnd := rand.New(rand.NewSource(22))
+	img := Image{ID: 1, Width: 80, Height: 60}
+	dets := make([]Annotation, 40)
+	grids := make([]MaskGrid, len(dets))
+	for i := range dets {
+		dets[i] = Annotation{Bbox: [4]float32{rnd.Float32() * 60, rnd.Float32() * 40, 5 + rnd.Float32()*20, 5 + rnd.Float32()*20}}
+		grids[i] = randomGrid(rnd, 28)
+	}
+	out, err := PasteMasks(img, dets, grids, 0.4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range dets {
+		want, _ := PasteMask(img, dets[i], grids[i], 0.4)
+		if out[i].Segmentation.SegmentationHelper.(*SegmentationRLE).Counts != want.Segmentation.SegmentationHelper.(*SegmentationRLE).Counts {
+			t.Fatalf("detection %d differs from PasteMask", i)
+		}
+	}
+	if _, err := PasteMasks(img, dets, grids[1:], 0.4); err == nil {
+		t.Fatal("expected an error for missing grids")
+	}
+}
@@ -0,0 +1,263 @@
+package coco
+
+import (
+	"errors"
+)
+
+//MaskRLE is a run-length encoded binary mask owned by go.
+//Size is [h, w] like the segmentation size and Counts alternate between runs
+//of zeros and ones in column-major order, starting with zeros.
+//MaskRLE values hold no C memory and can be shared between goroutines.
+type MaskRLE struct {
+	Size   [2]uint32
+	Counts []uint32
+}
+
+//NewMaskRLE converts a RLE or RLEUncompressed segmentation into a MaskRLE.
+//The counts are not checked, use DecodeSegment or Validate for that.
+func NewMaskRLE(segmentation SegmentationHelper) (MaskRLE, error) {
+	if segmentation == nil {
+		return MaskRLE{}, errors.New("empty segmentation")
+	}
+	switch segment := segmentation.(type) {
+	case *SegmentationRLE:
+		return segment.MaskRLE(), nil
+	case *SegmentationRLEUncompressed:
+		return segment.MaskRLE(), nil
+	}
+	return MaskRLE{}, errors.New("segmentation type " + segmentation.SegmentationType() + " has no size")
+}
+
+//MaskRLE decodes the compressed counts string without checking it, use
+//DecodeRLEString for counts that may be malformed
+func (s *SegmentationRLE) MaskRLE() MaskRLE {
+	return MaskRLE{Size: s.Size, Counts: countsFromString(s.Counts)}
+}
+
+//MaskRLE copies the uncompressed counts
+func (s *SegmentationRLEUncompressed) MaskRLE() MaskRLE {
+	return MaskRLE{Size: s.Size, Counts: append([]uint32(nil), s.Counts...)}
+}
+
+//Segmentation returns the compressed segmentation of r
+func (r MaskRLE) Segmentation() *SegmentationRLE {
+	return &SegmentationRLE{Counts: r.String(), Size: r.Size}
+}
+
+//Uncompressed returns the uncompressed segmentation of r
+func (r MaskRLE) Uncompressed() *SegmentationRLEUncompressed {
+	return &SegmentationRLEUncompressed{Counts: append([]uint32(nil), r.Counts...), Size: r.Size}
+}
+
+//String Get compressed string representation of encoded mask.
+func (r MaskRLE) String() string {
+	return countsToString(r.Counts)
+}
+
+//ParseString Convert from compressed string representation of encoded mask.
+//Malformed strings leave r unchanged and return a *RLEError.
+func (r *MaskRLE) ParseString(counts string, size [2]uint32) error {
+	rle, err := DecodeRLEString(counts, size)
+	if err != nil {
+		return err
+	}
+	*r = rle
+	return nil
+}
+
+//Encode binary mask using RLE, the mask is stored in column-major order.
+func (r *MaskRLE) Encode(mask []byte, size [2]uint32) {
+	r.Size = size
+	r.Counts = r.Counts[:0]
+	var p byte
+	c := uint32(0)
+	for _, v := range mask {
+		if v != 0 {
+			v = 1
+		}
+		if v != p {
+			r.Counts = append(r.Counts, c)
+			c = 0
+			p = v
+		}
+		c++
+	}
+	r.Counts = append(r.Counts, c)
+}
+
+//Decode binary mask encoded via RLE. Counts exceeding h*w are ignored.
+func (r MaskRLE) Decode() (mask []byte) {
+	mask = make([]byte, int(r.Size[0])*int(r.Size[1]))
+	pos := 0
+	for i, c := range r.Counts {
+		end := pos + int(c)
+		if end > len(mask) {
+			end = len(mask)
+		}
+		if i%2 == 1 {
+			for k := pos; k < end; k++ {
+				mask[k] = 1
+			}
+		}
+		pos = end
+	}
+	return mask
+}
+
+//Area Compute area of encoded mask.
+func (r MaskRLE) Area() uint32 {
+	a := uint32(0)
+	for j := 1; j < len(r.Counts); j += 2 {
+		a += r.Counts[j]
+	}
+	return a
+}
+
+//Bbox Get bounding box [x, y, w, h] surrounding encoded mask.
+func (r MaskRLE) Bbox() [4]float32 {
+	h, w := r.Size[0], r.Size[1]
+	m := len(r.Counts) / 2 * 2
+	if m == 0 || h == 0 {
+		return [4]float32{}
+	}
+	xs, ys, xe, ye := w, h, uint32(0), uint32(0)
+	cc, xp := uint32(0), uint32(0)
+	for j := 0; j < m; j++ {
+		cc += r.Counts[j]
+		t := cc - uint32(j%2)
+		y := t % h
+		x := (t - y) / h
+		if j%2 == 0 {
+			xp = x
+		} else if xp < x {
+			ys = 0
+			ye = h - 1
+		}
+		if x < xs {
+			xs = x
+		}
+		if x > xe {
+			xe = x
+		}
+		if y < ys {
+			ys = y
+		}
+		if y > ye {
+			ye = y
+		}
+	}
+	if xs > xe {
+		return [4]float32{}
+	}
+	return [4]float32{float32(xs), float32(ys), float32(xe - xs + 1), float32(ye - ys + 1)}
+}
+
+// rleWalk steps through two encodings at once and reports every run where
+// the values of a and b are constant.
+func rleWalk(a, b []uint32, fn func(c uint32, va, vb bool)) {
+	if len(a) == 0 || len(b) == 0 {
+		return
+	}
+	ca, cb := a[0], b[0]
+	va, vb := false, false
+	i, j := 1, 1
+	for {
+		c := ca
+		if cb < c {
+			c = cb
+		}
+		if c > 0 {
+			fn(c, va, vb)
+		}
+		ca -= c
+		for ca == 0 && i < len(a) {
+			ca = a[i]
+			i++
+			va = !va
+		}
+		cb -= c
+		for cb == 0 && j < len(b) {
+			cb = b[j]
+			j++
+			vb = !vb
+		}
+		if ca == 0 && cb == 0 {
+			return
+		}
+		if ca == 0 || cb == 0 {
+			// one encoding is shorter than the other, treat the rest as zeros
+			if ca == 0 {
+				va = false
+				ca = cb
+			} else {
+				vb = false
+				cb = ca
+			}
+		}
+	}
+}
+
+//Merge Compute union or intersection of r and o. Masks of a different size
+//give an empty RLE like rleMerge.
+func (r MaskRLE) Merge(o MaskRLE, intersect bool) MaskRLE {
+	if r.Size != o.Size {
+		return MaskRLE{}
+	}
+	out := MaskRLE{Size: r.Size}
+	v := false
+	cc := uint32(0)
+	rleWalk(r.Counts, o.Counts, func(c uint32, va, vb bool) {
+		nv := va || vb
+		if intersect {
+			nv = va && vb
+		}
+		if nv != v {
+			out.Counts = append(out.Counts, cc)
+			cc = 0
+			v = nv
+		}
+		cc += c
+	})
+	out.Counts = append(out.Counts, cc)
+	return out
+}
+
+//MergeRLEs Compute union or intersection of encoded masks.
+func MergeRLEs(rles []MaskRLE, intersect bool) MaskRLE {
+	if len(rles) == 0 {
+		return MaskRLE{}
+	}
+	out := rles[0]
+	for i := 1; i < len(rles); i++ {
+		out = out.Merge(rles[i], intersect)
+	}
+	return out
+}
+
+//IoU Compute intersection over union between r as detection and gt. For a
+//crowd gt the union is the area of r. Masks of a different size give -1.
+func (r MaskRLE) IoU(gt MaskRLE, iscrowd bool) float64 {
+	if r.Size != gt.Size {
+		return -1
+	}
+	i, u := r.intersectUnion(gt)
+	if i == 0 {
+		return 0
+	}
+	if iscrowd {
+		u = uint64(r.Area())
+	}
+	return float64(i) / float64(u)
+}
+
+func (r MaskRLE) intersectUnion(gt MaskRLE) (i, u uint64) {
+	rleWalk(r.Counts, gt.Counts, func(c uint32, va, vb bool) {
+		if va || vb {
+			u += uint64(c)
+			if va && vb {
+				i += uint64(c)
+			}
+		}
+	})
+	return
+}
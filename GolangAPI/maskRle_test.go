@@ -0,0 +1,125 @@
+package coco
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func randomRLEs(rnd *rand.Rand, n, h, w int) []MaskRLE {
+	rles := make([]MaskRLE, n)
+	for i := range rles {
+		rles[i].Encode(randomMask(rnd, h, w), [2]uint32{uint32(h), uint32(w)})
+	}
+	return rles
+}
+
+func Test_RLEMatchesC(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	h, w := 23, 31
+	rles := randomRLEs(rnd, 8, h, w)
+	crles := ToRLE(rles)
+
+	areas := crles.AreaRLE()
+	bbs := crles.ToBB()
+	for i, r := range rles {
+		if r.Area() != areas[i] {
+			t.Fatalf("area %d: go %d, C %d", i, r.Area(), areas[i])
+		}
+		bb := r.Bbox()
+		for k := 0; k < 4; k++ {
+			if float64(bb[k]) != bbs[4*i+k] {
+				t.Fatalf("bbox %d: go %v, C %v", i, bb, bbs[4*i:4*i+4])
+			}
+		}
+		if c := crles.MaskRLE(i); c.String() != r.String() {
+			t.Fatalf("C round trip %d: %s != %s", i, c.String(), r.String())
+		}
+	}
+
+	iscrowd := []byte{0, 1, 0, 0, 1, 0, 0, 0}
+	ious := IoURLE(crles, crles, iscrowd)
+	for g := range rles {
+		for d := range rles {
+			if got := rles[d].IoU(rles[g], iscrowd[g] == 1); got != ious[g*len(rles)+d] {
+				t.Fatalf("iou dt %d gt %d: go %v, C %v", d, g, got, ious[g*len(rles)+d])
+			}
+		}
+	}
+
+	union := MergeRLEs(rles, false)
+	inter := MergeRLEs(rles[:2], true)
+	masks := make([][]byte, len(rles))
+	for i := range rles {
+		masks[i] = rles[i].Decode()
+	}
+	um, im := union.Decode(), inter.Decode()
+	for p := range um {
+		u, in := byte(0), masks[0][p]&masks[1][p]
+		for i := range masks {
+			u |= masks[i][p]
+		}
+		if um[p] != u || im[p] != in {
+			t.Fatalf("merge mismatch at %d", p)
+		}
+	}
+	if got := crles.Merge(false).MaskRLE(0); got.String() != union.String() {
+		t.Fatalf("C merge %v, go merge %v", got, union)
+	}
+}
+
+func Test_RLESegmentationRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(5))
+	for _, r := range randomRLEs(rnd, 20, 17, 13) {
+		seg := r.Segmentation()
+		back, err := NewMaskRLE(seg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back.String() != seg.Counts || back.Size != r.Size {
+			t.Fatalf("compressed round trip failed")
+		}
+		unc, _ := NewMaskRLE(r.Uncompressed())
+		if !equalCounts(unc.Counts, r.Counts) {
+			t.Fatalf("uncompressed round trip failed")
+		}
+		var parsed MaskRLE
+		parsed.ParseString(seg.Counts, seg.Size)
+		if !equalCounts(parsed.Counts, r.Counts) {
+			t.Fatalf("ParseString round trip failed")
+		}
+	}
+}
+
+func Test_RLEConcurrent(t *testing.T) {
+	rnd := rand.New(rand.NewSource(9))
+	rles := randomRLEs(rnd, 16, 40, 40)
+	var wg sync.WaitGroup
+	for k := 0; k < 8; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				a, b := rles[i%len(rles)], rles[(i*7)%len(rles)]
+				if a.IoU(b, false) != b.IoU(a, false) {
+					t.Error("iou is not symmetric")
+					return
+				}
+				DecodeSegmentToMask(a.Segmentation())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func equalUint32(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
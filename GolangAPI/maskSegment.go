@@ -0,0 +1,177 @@
+package coco
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+//SegmentationBbox is a [x, y, w, h] box used in place of a segmentation,
+//e.g. to compare a box against a mask with SegmentIoU
+type SegmentationBbox [4]float32
+
+func (s SegmentationBbox) SegmentationType() string {
+	return "Bbox"
+}
+
+type segmentKey struct {
+	seg  SegmentationHelper
+	h, w uint32
+}
+
+//SegmentCache caches the RLE of every segmentation it converted, keyed by
+//the segmentation value. Annotations loaded from one CocoApi share their
+//segmentation, so repeated queries on the same annotations convert each
+//of them once. A SegmentCache is safe for concurrent use.
+type SegmentCache struct {
+	mu   sync.Mutex
+	rles map[segmentKey]MaskRLE
+}
+
+//NewSegmentCache returns an empty cache
+func NewSegmentCache() *SegmentCache {
+	return &SegmentCache{rles: make(map[segmentKey]MaskRLE)}
+}
+
+//MaskRLE converts any segmentation into a MaskRLE of size h x w. Polygons
+//and boxes are rasterized, RLEs must already have that size.
+func (c *SegmentCache) MaskRLE(seg Segment, h, w uint32) (MaskRLE, error) {
+	if seg.SegmentationHelper == nil {
+		return MaskRLE{}, errors.New("empty segmentation")
+	}
+	key := segmentKey{seg.SegmentationHelper, h, w}
+	c.mu.Lock()
+	rle, ok := c.rles[key]
+	c.mu.Unlock()
+	if ok {
+		return rle, nil
+	}
+
+	switch s := seg.SegmentationHelper.(type) {
+	case SegmentationBbox:
+		bb := BB{float64(s[0]), float64(s[1]), float64(s[2]), float64(s[3])}
+		rle = bb.ToRLE(h, w, 1).MaskRLE(0)
+	case *SegmentationBbox:
+		bb := BB{float64(s[0]), float64(s[1]), float64(s[2]), float64(s[3])}
+		rle = bb.ToRLE(h, w, 1).MaskRLE(0)
+	default:
+		cnts, err := rasterizeSegment(s, h, w)
+		if err != nil {
+			return MaskRLE{}, err
+		}
+		rle = MaskRLE{Size: [2]uint32{h, w}, Counts: cnts}
+	}
+
+	c.mu.Lock()
+	c.rles[key] = rle
+	c.mu.Unlock()
+	return rle, nil
+}
+
+// forget drops the RLEs of seg, for segmentations that were edited or removed.
+func (c *SegmentCache) forget(seg SegmentationHelper) {
+	if seg == nil {
+		return
+	}
+	c.mu.Lock()
+	for key := range c.rles {
+		if key.seg == seg {
+			delete(c.rles, key)
+		}
+	}
+	c.mu.Unlock()
+}
+
+//IoU Compute intersection over union between two segmentations of any
+//representation on a h x w image, for a crowd gt the union is the area of dt
+func (c *SegmentCache) IoU(dt, gt Segment, h, w uint32, iscrowd bool) (float64, error) {
+	a, err := c.MaskRLE(dt, h, w)
+	if err != nil {
+		return 0, err
+	}
+	b, err := c.MaskRLE(gt, h, w)
+	if err != nil {
+		return 0, err
+	}
+	return a.IoU(b, iscrowd), nil
+}
+
+//IoUMatrix Compute intersection over union between all pairs of dt and gt,
+//stored as out[g*len(dt)+d] like IoURLE. iscrowd may be nil.
+func (c *SegmentCache) IoUMatrix(dt, gt []Segment, h, w uint32, iscrowd []byte) ([]float64, error) {
+	dr := make([]MaskRLE, len(dt))
+	for i := range dt {
+		rle, err := c.MaskRLE(dt[i], h, w)
+		if err != nil {
+			return nil, err
+		}
+		dr[i] = rle
+	}
+	gr := make([]MaskRLE, len(gt))
+	for i := range gt {
+		rle, err := c.MaskRLE(gt[i], h, w)
+		if err != nil {
+			return nil, err
+		}
+		gr[i] = rle
+	}
+	return IoUBatch(context.Background(), dr, gr, iscrowd, IoUOptions{})
+}
+
+//SegmentIoU Compute intersection over union between two segmentations of
+//any representation on a h x w image
+func SegmentIoU(dt, gt Segment, h, w uint32, iscrowd bool) (float64, error) {
+	return NewSegmentCache().IoU(dt, gt, h, w, iscrowd)
+}
+
+//SegmentIoUMatrix Compute intersection over union between all pairs of
+//segmentations of any representation, stored as out[g*len(dt)+d]
+func SegmentIoUMatrix(dt, gt []Segment, h, w uint32, iscrowd []byte) ([]float64, error) {
+	return NewSegmentCache().IoUMatrix(dt, gt, h, w, iscrowd)
+}
+
+//AnnIoU Compute intersection over union between the segmentations of two
+//annotations of the same image, gt decides the crowd semantics.
+//Conversions are cached per annotation.
+func (api *CocoApi) AnnIoU(dtId, gtId int) (float64, error) {
+	out, err := api.AnnIoUMatrix([]int{dtId}, []int{gtId})
+	if err != nil {
+		return 0, err
+	}
+	return out[0], nil
+}
+
+//AnnIoUMatrix Compute intersection over union between the segmentations of
+//annotations of the same image, stored as out[g*len(dtIds)+d]
+func (api *CocoApi) AnnIoUMatrix(dtIds, gtIds []int) ([]float64, error) {
+	imgId := -1
+	segments := func(ids []int) ([]Segment, error) {
+		segs := make([]Segment, len(ids))
+		for i, id := range ids {
+			ann, ok := api.annMap[id]
+			if !ok {
+				return nil, errors.New("annotation not found")
+			}
+			if imgId >= 0 && ann.ImageID != imgId {
+				return nil, errors.New("annotations belong to different images")
+			}
+			imgId = ann.ImageID
+			segs[i] = ann.Segmentation
+		}
+		return segs, nil
+	}
+	dt, err := segments(dtIds)
+	if err != nil {
+		return nil, err
+	}
+	gt, err := segments(gtIds)
+	if err != nil {
+		return nil, err
+	}
+	iscrowd := make([]byte, len(gtIds))
+	for i, id := range gtIds {
+		iscrowd[i] = api.annMap[id].Iscrowd
+	}
+	img := api.imgMap[imgId]
+	return api.segCache.IoUMatrix(dt, gt, uint32(img.Height), uint32(img.Width), iscrowd)
+}
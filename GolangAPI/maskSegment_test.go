@@ -0,0 +1,58 @@
+package coco
+
+import (
+	"testing"
+)
+
+func Test_SegmentIoU(t *testing.T) {
+	h, w := uint32(20), uint32(20)
+	poly := &SegmentationPolygon{{2, 2, 12, 2, 12, 12, 2, 12}}
+	box := SegmentationBbox{2, 2, 10, 10}
+	empty := MaskRLE{Size: [2]uint32{h, w}, Counts: []uint32{h * w}}
+	polyRLE, err := NewSegmentCache().MaskRLE(Segment{poly}, h, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iou, err := SegmentIoU(Segment{poly}, Segment{box}, h, w, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iou <= 0.6 {
+		t.Fatalf("polygon and its box overlap by %v", iou)
+	}
+	unc := polyRLE.Uncompressed()
+	out, err := SegmentIoUMatrix([]Segment{{poly}, {box}}, []Segment{{unc}, {polyRLE.Segmentation()}}, h, w, []byte{0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[0] != 1 || out[2] != 1 || out[1] != iou || out[3] != 1 {
+		t.Fatalf("unexpected iou matrix %v", out)
+	}
+	if _, err := SegmentIoU(Segment{poly}, Segment{empty.Segmentation()}, h+1, w, false); err == nil {
+		t.Fatal("expected size mismatch error")
+	}
+}
+
+func Test_AnnIoU(t *testing.T) {
+	api, err := NewCocoApi(datasetMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgIds := api.GetImgIds(nil)
+	ids := api.GetAnnIds(imgIds[:1], nil, nil, 3)
+	out, err := api.AnnIoUMatrix(ids, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range ids {
+		if out[i*len(ids)+i] != 1 {
+			t.Fatalf("annotation %d does not match itself: %v", ids[i], out[i*len(ids)+i])
+		}
+	}
+	if len(api.segCache.rles) != len(ids) {
+		t.Fatalf("cache holds %d entries for %d annotations", len(api.segCache.rles), len(ids))
+	}
+	if _, err := api.AnnIoU(ids[0], -1); err == nil {
+		t.Fatal("expected error for unknown annotation")
+	}
+}
@@ -0,0 +1,150 @@
+package coco
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Go port of rleToString / rleFrString from common/maskApi.c.
+// The compressed counts string is similar to LEB128 but uses 6 bits/char
+// and ascii chars 48-111; every count after the second is stored as the
+// difference to the count two positions before it.
+
+var (
+	ErrRLEInvalidChar = errors.New("invalid character in rle counts")
+	ErrRLETruncated   = errors.New("truncated rle count")
+	ErrRLEOverflow    = errors.New("rle count out of range")
+	ErrRLELength      = errors.New("rle counts do not sum to h*w")
+)
+
+//RLEError is a malformed RLE, Err is one of the ErrRLE* errors and Offset
+//is the byte offset into the counts string (the index into the counts for
+//ErrRLELength)
+type RLEError struct {
+	Err    error
+	Offset int
+}
+
+func (e *RLEError) Error() string {
+	return fmt.Sprintf("%s at %d", e.Err.Error(), e.Offset)
+}
+
+func (e *RLEError) Unwrap() error {
+	return e.Err
+}
+
+//DecodeRLEString decodes a compressed counts string and checks that it is
+//well formed and that the counts sum to h*w
+func DecodeRLEString(counts string, size [2]uint32) (MaskRLE, error) {
+	cnts, err := decodeCounts(counts, true)
+	if err != nil {
+		return MaskRLE{}, err
+	}
+	rle := MaskRLE{Size: size, Counts: cnts}
+	if err := rle.Validate(); err != nil {
+		return MaskRLE{}, err
+	}
+	return rle, nil
+}
+
+//Validate checks that the counts sum to h*w
+func (r MaskRLE) Validate() error {
+	total := uint64(r.Size[0]) * uint64(r.Size[1])
+	sum := uint64(0)
+	for i, c := range r.Counts {
+		sum += uint64(c)
+		if sum > total {
+			return &RLEError{Err: ErrRLELength, Offset: i}
+		}
+	}
+	if sum != total {
+		return &RLEError{Err: ErrRLELength, Offset: len(r.Counts)}
+	}
+	return nil
+}
+
+//Validate checks that the counts string is well formed and sums to h*w
+func (s *SegmentationRLE) Validate() error {
+	_, err := DecodeRLEString(s.Counts, s.Size)
+	return err
+}
+
+//Validate checks that the counts sum to h*w
+func (s *SegmentationRLEUncompressed) Validate() error {
+	return MaskRLE{Size: s.Size, Counts: s.Counts}.Validate()
+}
+
+//countsToString Get compressed string representation of uncompressed counts.
+func countsToString(cnts []uint32) string {
+	s := make([]byte, 0, len(cnts)*2)
+	for i := 0; i < len(cnts); i++ {
+		x := int64(cnts[i])
+		if i > 2 {
+			x -= int64(cnts[i-2])
+		}
+		more := true
+		for more {
+			c := byte(x & 0x1f)
+			x >>= 5
+			if c&0x10 != 0 {
+				more = x != -1
+			} else {
+				more = x != 0
+			}
+			if more {
+				c |= 0x20
+			}
+			s = append(s, c+48)
+		}
+	}
+	return string(s)
+}
+
+//countsFromString Convert from compressed string representation to uncompressed counts.
+//Malformed input is decoded as leniently as rleFrString does, see DecodeRLEString.
+func countsFromString(s string) []uint32 {
+	cnts, _ := decodeCounts(s, false)
+	return cnts
+}
+
+// decodeCounts decodes a counts string. In strict mode the first malformed
+// count stops decoding with a *RLEError.
+func decodeCounts(s string, strict bool) ([]uint32, error) {
+	cnts := make([]uint32, 0, len(s))
+	p := 0
+	for p < len(s) {
+		var x int64
+		start := p
+		k := uint(0)
+		more := true
+		for more && p < len(s) {
+			if strict && (s[p] < 48 || s[p] > 111) {
+				return cnts, &RLEError{Err: ErrRLEInvalidChar, Offset: p}
+			}
+			if strict && k >= 7 {
+				return cnts, &RLEError{Err: ErrRLEOverflow, Offset: start}
+			}
+			c := int64(s[p]) - 48
+			x |= (c & 0x1f) << (5 * k)
+			more = c&0x20 != 0
+			p++
+			k++
+			if !more && c&0x10 != 0 {
+				x |= -1 << (5 * k)
+			}
+		}
+		if strict && more {
+			return cnts, &RLEError{Err: ErrRLETruncated, Offset: start}
+		}
+		m := len(cnts)
+		if m > 2 {
+			x += int64(cnts[m-2])
+		}
+		if strict && (x < 0 || x > math.MaxUint32) {
+			return cnts, &RLEError{Err: ErrRLEOverflow, Offset: start}
+		}
+		cnts = append(cnts, uint32(x))
+	}
+	return cnts, nil
+}
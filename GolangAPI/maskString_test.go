@@ -0,0 +1,110 @@
+package coco
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_DecodeRLEString(t *testing.T) {
+	size := [2]uint32{5, 6}
+	valid := EncodeMaskToSegment([]byte{0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 1, 1, 0, 1, 1, 0, 0, 0, 0, 0, 1, 1, 0, 1, 1}, size)
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("valid rle rejected: %v", err)
+	}
+	cases := []struct {
+		counts string
+		size   [2]uint32
+		err    error
+	}{
+		{valid.Counts, [2]uint32{5, 7}, ErrRLELength},
+		{valid.Counts + "1", size, ErrRLELength},
+		{"5 4", size, ErrRLEInvalidChar},
+		{"5\x004", size, ErrRLEInvalidChar},
+		{"5P", size, ErrRLETruncated},
+		{"PPPPPPPP0", size, ErrRLEOverflow},
+		{"0O", size, ErrRLEOverflow},
+		{"", size, ErrRLELength},
+	}
+	for _, c := range cases {
+		_, err := DecodeRLEString(c.counts, c.size)
+		if !errors.Is(err, c.err) {
+			t.Fatalf("%q: got %v, want %v", c.counts, err, c.err)
+		}
+	}
+	if err := (&SegmentationRLEUncompressed{Counts: []uint32{10, 20}, Size: size}).Validate(); err != nil {
+		t.Fatalf("valid uncompressed rle rejected: %v", err)
+	}
+	if err := (&SegmentationRLEUncompressed{Counts: []uint32{10, 21}, Size: size}).Validate(); !errors.Is(err, ErrRLELength) {
+		t.Fatalf("got %v, want %v", err, ErrRLELength)
+	}
+	// malformed segmentations are rejected by the strict DecodeSegment, the
+	// lenient decoders still return a mask of their size
+	for _, c := range []struct {
+		seg    SegmentationHelper
+		pixels int
+	}{
+		{&SegmentationRLE{Size: size}, 30},
+		{&SegmentationRLE{Counts: "5P", Size: size}, 30},
+		{&SegmentationRLE{Counts: valid.Counts, Size: [2]uint32{5, 7}}, 35},
+		{&SegmentationRLEUncompressed{Counts: []uint32{10, 21}, Size: size}, 30},
+	} {
+		if _, err := DecodeSegment(c.seg); err == nil {
+			t.Fatalf("DecodeSegment accepted %+v", c.seg)
+		}
+		if mask := DecodeSegmentToMask(c.seg); len(mask) != c.pixels {
+			t.Fatalf("DecodeSegmentToMask decoded %+v to %d pixels", c.seg, len(mask))
+		}
+		if rle, err := NewMaskRLE(c.seg); err != nil || len(rle.Decode()) != c.pixels {
+			t.Fatalf("NewMaskRLE decoded %+v: %v", c.seg, err)
+		}
+	}
+	var rle MaskRLE
+	if err := rle.ParseString("5P", size); err == nil || rle.Counts != nil {
+		t.Fatalf("ParseString accepted a truncated string")
+	}
+}
+
+// varintsFitC reports whether every count is at most 6 characters long, the
+// C decoder shifts an int and is only defined for those.
+func varintsFitC(s string) bool {
+	k := 0
+	for i := 0; i < len(s); i++ {
+		k++
+		if k > 6 {
+			return false
+		}
+		if (s[i]-48)&0x20 == 0 {
+			k = 0
+		}
+	}
+	return true
+}
+
+func FuzzRLEString(f *testing.F) {
+	f.Add("564LM040L0")
+	f.Add("XS^51;0Gb0g8_O`G3l0LoNP2\\8WNdG27GFm2Y8Y1O9iGcKO=Y7f4O1O1EkJTI\\5Z6eJfI02e5U6?K5O12N0000L4O1O1F:O1000000O11OO10000000O10O1001O00O100O1O12N00O2O01O001ON2O1O1O11O1O001OQL")
+	f.Add("5P")
+	f.Add("0O")
+	f.Fuzz(func(t *testing.T, s string) {
+		cnts, err := decodeCounts(s, true)
+		lenient := countsFromString(s)
+		if err != nil {
+			var rerr *RLEError
+			if !errors.As(err, &rerr) {
+				t.Fatalf("untyped error %v", err)
+			}
+			return
+		}
+		if !equalUint32(cnts, lenient) {
+			t.Fatalf("strict and lenient decoding differ for %q", s)
+		}
+		if !equalUint32(countsFromString(countsToString(cnts)), cnts) {
+			t.Fatalf("re-encoding changed the counts of %q", s)
+		}
+		if varintsFitC(s) {
+			if c := cCountsFromString(s, 0, 0); !equalUint32(c, cnts) {
+				t.Fatalf("go %v, C %v for %q", cnts, c, s)
+			}
+		}
+	})
+}